@@ -4,12 +4,14 @@ import (
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"simple-web-app/pkg/validator"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -25,28 +27,31 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// read a json payload
 	err := app.readJSON(w, r, &cred)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
 	// look up the user by email address
-	user, err := app.DB.GetUserByEmail(cred.Username)
-	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	user, err := app.DB.GetUserByEmail(r.Context(), cred.Username)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
 	// check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(cred.Password))
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
-	// generate tokens
-	tokenPairs, err := app.generateTokenPair(user)
+	// generate tokens; a fresh login starts a new refresh token family
+	tokenPairs, err := app.issueTokenPair(r.Context(), user, "")
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
@@ -71,44 +76,27 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		app.errorJSON(w, r, err)
 		return
 	}
 
 	refreshToken := r.Form.Get("refresh_token")
-	claims := &Claims{}
-
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
 
+	claims, err := app.parseRefreshToken(refreshToken)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token doesnot need renew yet"), http.StatusTooEarly)
-		return
-	}
-
-	// get user id from claims
-
-	userID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-
-	user, err := app.DB.GetUser(userID)
-	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		app.errorJSON(w, r, errors.New("refresh token doesnot need renew yet"), http.StatusTooEarly)
 		return
 	}
 
-	tokenPairs, err := app.generateTokenPair(user)
+	// exchange the refresh token for a new pair
+	tokenPairs, err := app.rotateRefreshToken(r.Context(), claims)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.refreshErrorJSON(w, r, err)
 		return
 	}
 
@@ -129,45 +117,18 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Request) {
-	log.Println("handler refreshUsingCookie executed")
 	for _, cookie := range r.Cookies() {
-		log.Println("cookie: ", cookie.Name)
 		if cookie.Name == "__Host-refresh_token" {
-			log.Println("cookie found! ", cookie.Value)
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-				return []byte(app.JWTSecret), nil
-			})
-
-			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
-				return
-			}
-
-			// if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-			// 	app.errorJSON(w, errors.New("refresh token doesnot need renew yet"), http.StatusTooEarly)
-			// 	return
-			// }
-
-			// get user id from claims
-
-			userID, err := strconv.Atoi(claims.Subject)
+			claims, err := app.parseRefreshToken(cookie.Value)
 			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.errorJSON(w, r, err, http.StatusBadRequest)
 				return
 			}
 
-			user, err := app.DB.GetUser(userID)
+			// exchange the refresh token for a new pair
+			tokenPairs, err := app.rotateRefreshToken(r.Context(), claims)
 			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
-				return
-			}
-
-			tokenPairs, err := app.generateTokenPair(user)
-			if err != nil {
-				app.errorJSON(w, err, http.StatusBadRequest)
+				app.refreshErrorJSON(w, r, err)
 				return
 			}
 
@@ -188,28 +149,139 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+}
+
+// refreshErrorJSON reports a failed refresh token rotation. Unknown and reused
+// tokens are the caller's problem; anything else comes from the repository.
+func (app *application) refreshErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errRefreshTokenUnknown), errors.Is(err, errRefreshTokenReused):
+		app.errorJSON(w, r, err, http.StatusUnauthorized)
+	default:
+		app.dbErrorJSON(w, r, err)
+	}
+}
+
+// UserListMetadata describes where a page of users sits in the full result.
+type UserListMetadata struct {
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PerPage  int `json:"per_page"`
+	LastPage int `json:"last_page"`
+}
+
+// UserListLinks holds the urls of the neighbouring pages; a link is empty if
+// there is no such page.
+type UserListLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// UserList is the envelope returned by GET /users.
+type UserList struct {
+	Users    []*data.User     `json:"users"`
+	Metadata UserListMetadata `json:"metadata"`
+	Links    UserListLinks    `json:"links"`
 }
 
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	filter, err := readUserFilter(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	users, total, err := app.DB.ListUsers(r.Context(), filter)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, users)
+	if users == nil {
+		users = []*data.User{}
+	}
+
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+	lastPage := (total + filter.Limit() - 1) / filter.Limit()
+	if lastPage < 1 {
+		lastPage = 1
+	}
+
+	list := UserList{
+		Users: users,
+		Metadata: UserListMetadata{
+			Total:    total,
+			Page:     page,
+			PerPage:  filter.Limit(),
+			LastPage: lastPage,
+		},
+		Links: UserListLinks{
+			Self: repository.PageURL(r.URL, page),
+		},
+	}
+	if page < lastPage {
+		list.Links.Next = repository.PageURL(r.URL, page+1)
+	}
+	if page > 1 {
+		list.Links.Prev = repository.PageURL(r.URL, page-1)
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, list)
+}
+
+// readUserFilter builds a repository.UserFilter from the query string of a
+// GET /users request, e.g. ?page=2&per_page=10&name=smith&is_admin=false&sort=-created_at
+func readUserFilter(qs url.Values) (repository.UserFilter, error) {
+	var filter repository.UserFilter
+	var err error
+
+	filter.Email = qs.Get("email")
+	filter.Name = qs.Get("name")
+	filter.Sort = qs.Get("sort")
+
+	if v := qs.Get("page"); v != "" {
+		filter.Page, err = strconv.Atoi(v)
+		if err != nil || filter.Page < 1 {
+			return filter, &repository.ValidationError{Field: "page", Message: "must be a positive number"}
+		}
+	}
+
+	if v := qs.Get("per_page"); v != "" {
+		filter.PerPage, err = strconv.Atoi(v)
+		if err != nil || filter.PerPage < 1 {
+			return filter, &repository.ValidationError{Field: "per_page", Message: "must be a positive number"}
+		}
+	}
+
+	if v := qs.Get("is_admin"); v != "" {
+		isAdmin, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, &repository.ValidationError{Field: "is_admin", Message: "must be true or false"}
+		}
+		admin := 0
+		if isAdmin {
+			admin = 1
+		}
+		filter.IsAdmin = &admin
+	}
+
+	return filter, filter.Validate()
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
-	user, err := app.DB.GetUser(userID)
+	user, err := app.DB.GetUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 
@@ -221,12 +293,40 @@ func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
 	err := app.readJSON(w, r, &user)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// only admins may edit other users, or hand out admin rights
+	claims := claimsFromContext(r.Context())
+	if claims == nil {
+		app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	if !claims.Admin {
+		callerID, err := claims.UserID()
+		if err != nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		if user.ID != callerID || user.IsAdmin != 0 {
+			app.errorJSON(w, r, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+	}
+
+	v := userValidator(user)
+	v.Check(user.ID > 0, "id", "This field must be a positive number")
+	if err := v.Err(); err != nil {
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
 		return
 	}
-	err = app.DB.UpdateUser(user)
+
+	err = app.DB.UpdateUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -235,12 +335,12 @@ func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
-	err = app.DB.DeleteUser(userID)
+	err = app.DB.DeleteUser(r.Context(), userID)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -250,18 +350,63 @@ func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
 	var user data.User
 	err := app.readJSON(w, r, &user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
-	_, err = app.DB.InsertUser(user)
+	if err := userValidator(user).Err(); err != nil {
+		app.errorJSON(w, r, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	_, err = app.DB.InsertUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.dbErrorJSON(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// userValidator checks the fields of a user posted to insertUser or
+// updateUser, within the limits of the users table.
+func userValidator(u data.User) *validator.Validator {
+	v := validator.New()
+	v.Field("email", u.Email, validator.Required(), validator.Email(), validator.MaxLength(255))
+	v.Field("first_name", u.FirstName, validator.Required(), validator.MaxLength(255))
+	v.Field("last_name", u.LastName, validator.Required(), validator.MaxLength(255))
+	v.Check(u.IsAdmin == 0 || u.IsAdmin == 1, "is_admin", "This field must be 0 or 1")
+	return v
+}
+
+// logout revokes the refresh token posted in the refresh_token form field,
+// along with every token rotated from the same login.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorJSON(w, r, err)
+		return
+	}
+
+	err = app.revokeRefreshToken(r.Context(), r.Form.Get("refresh_token"))
+	if err != nil {
+		log.Println("could not revoke refresh token:", err)
+		app.errorJSON(w, r, errors.New("could not log out"), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
+	// revoke the token server side, so that a copy of the cookie is useless too
+	if cookie, err := r.Cookie("__Host-refresh_token"); err == nil {
+		err = app.revokeRefreshToken(r.Context(), cookie.Value)
+		if err != nil {
+			log.Println("could not revoke refresh token:", err)
+			app.errorJSON(w, r, errors.New("could not log out"), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	delCookie := http.Cookie{
 		Name:     "__Host-refresh_token",
 		Path:     "/",
@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
 	"strings"
 	"testing"
 	"time"
@@ -71,7 +75,7 @@ func Test_app_refresh(t *testing.T) {
 			if test.resetRefreshTime {
 				refreshTokenExpiry = time.Second * 1
 			}
-			tokens, _ := app.generateTokenPair(&testUser)
+			tokens, _ := app.issueTokenPair(context.Background(), &testUser, "")
 			tkn = tokens.RefreshToken
 		} else {
 			tkn = test.token
@@ -107,10 +111,11 @@ func Test_app_userHandlers(t *testing.T) {
 		expectedStatus int
 	}{
 		{"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
-		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
+		{"deleteUser", "DELETE", "", "2", app.deleteUser, http.StatusNoContent},
+		{"deleteUser not found", "DELETE", "", "2", app.deleteUser, http.StatusNotFound},
 		{"deleteUser bad url param", "DELETE", "", "y", app.deleteUser, http.StatusBadRequest},
 		{"getUser valid", "GET", "", "1", app.getUser, http.StatusOK},
-		{"getUser invalid", "GET", "", "100", app.getUser, http.StatusBadRequest},
+		{"getUser invalid", "GET", "", "100", app.getUser, http.StatusNotFound},
 		{"getUser bad url param", "GET", "", "y", app.getUser, http.StatusBadRequest},
 
 		{
@@ -127,7 +132,7 @@ func Test_app_userHandlers(t *testing.T) {
 			`{"id":100,"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`,
 			"",
 			app.updateUser,
-			http.StatusBadRequest,
+			http.StatusNotFound,
 		},
 		{
 			"updateUser invalid json",
@@ -145,6 +150,62 @@ func Test_app_userHandlers(t *testing.T) {
 			app.insertUser,
 			http.StatusNoContent,
 		},
+		{
+			"insert duplicate email",
+			"PUT",
+			`{"first_name":"Jack","last_name":"Smith","email":"jack@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusConflict,
+		},
+		{
+			"insert without email",
+			"PUT",
+			`{"first_name":"Jack","last_name":"Smith","email":""}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insert invalid email",
+			"PUT",
+			`{"first_name":"Jack","last_name":"Smith","email":"jack"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"insert without name",
+			"PUT",
+			`{"first_name":"","last_name":"Smith","email":"john@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"updateUser without id",
+			"PATCH",
+			`{"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`,
+			"",
+			app.updateUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"updateUser invalid is_admin",
+			"PATCH",
+			`{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":2}`,
+			"",
+			app.updateUser,
+			http.StatusUnprocessableEntity,
+		},
+		{
+			"updateUser duplicate email",
+			"PATCH",
+			`{"id":1,"first_name":"Administrator","last_name":"User","email":"JACK@example.com"}`,
+			"",
+			app.updateUser,
+			http.StatusConflict,
+		},
 		{
 			"insert invalid",
 			"PUT",
@@ -163,6 +224,8 @@ func Test_app_userHandlers(t *testing.T) {
 		},
 	}
 
+	resetDB()
+
 	for _, test := range tests {
 		var req *http.Request
 		if test.json == "" {
@@ -176,6 +239,9 @@ func Test_app_userHandlers(t *testing.T) {
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
 		}
 
+		// the handlers are called as an admin, as authRequired would have done
+		req = addClaimsToReq(req, 1, true)
+
 		rr := httptest.NewRecorder()
 		handler := http.HandlerFunc(test.handler)
 		handler.ServeHTTP(rr, req)
@@ -186,9 +252,108 @@ func Test_app_userHandlers(t *testing.T) {
 	}
 }
 
+func Test_app_updateUserAsNonAdmin(t *testing.T) {
+	var tests = []struct {
+		name           string
+		json           string
+		addClaims      bool
+		expectedStatus int
+	}{
+		{"own record", `{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`, true, http.StatusNoContent},
+		{"someone else's record", `{"id":2,"first_name":"Jack","last_name":"Smith","email":"jack@example.com"}`, true, http.StatusForbidden},
+		{"grant self admin", `{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com","is_admin":1}`, true, http.StatusForbidden},
+		{"no claims", `{"id":1,"first_name":"Administrator","last_name":"User","email":"admin@example.com"}`, false, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("PATCH", "/", strings.NewReader(test.json))
+		if test.addClaims {
+			req = addClaimsToReq(req, 1, false)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.updateUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, got %d", test.name, test.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func addClaimsToReq(req *http.Request, userID int, admin bool) *http.Request {
+	claims := &Claims{Admin: admin}
+	claims.Subject = fmt.Sprint(userID)
+	return req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+}
+
+func Test_app_allUsers(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedCount  int
+		expectedTotal  int
+		expectNext     bool
+	}{
+		{"no parameters", "", http.StatusOK, 2, 2, false},
+		{"paginated", "?per_page=1", http.StatusOK, 1, 2, true},
+		{"last page", "?per_page=1&page=2", http.StatusOK, 1, 2, false},
+		{"past last page", "?page=5", http.StatusOK, 0, 2, false},
+		{"filter by email", "?email=JACK@", http.StatusOK, 1, 1, false},
+		{"filter by name", "?name=admin", http.StatusOK, 1, 1, false},
+		{"filter by is_admin", "?is_admin=false", http.StatusOK, 1, 1, false},
+		{"sorted descending", "?sort=-email", http.StatusOK, 2, 2, false},
+		{"bad sort field", "?sort=password", http.StatusBadRequest, 0, 0, false},
+		{"bad page", "?page=0", http.StatusBadRequest, 0, 0, false},
+		{"bad per_page", "?per_page=1000", http.StatusBadRequest, 0, 0, false},
+		{"bad is_admin", "?is_admin=maybe", http.StatusBadRequest, 0, 0, false},
+	}
+
+	resetDB()
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/users/"+test.query, nil)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.allUsers)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: wrong status returned; expected %d, got %d", test.name, test.expectedStatus, rr.Code)
+			continue
+		}
+
+		if test.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var list UserList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Errorf("%s: could not decode response: %s", test.name, err)
+			continue
+		}
+
+		if len(list.Users) != test.expectedCount {
+			t.Errorf("%s: expected %d users, got %d", test.name, test.expectedCount, len(list.Users))
+		}
+
+		if list.Metadata.Total != test.expectedTotal {
+			t.Errorf("%s: expected total of %d, got %d", test.name, test.expectedTotal, list.Metadata.Total)
+		}
+
+		if test.expectNext && list.Links.Next == "" {
+			t.Errorf("%s: expected a next link, but did not get one", test.name)
+		}
+
+		if !test.expectNext && list.Links.Next != "" {
+			t.Errorf("%s: did not expect a next link, but got %s", test.name, list.Links.Next)
+		}
+	}
+}
+
 func Test_app_refreshUsingCookie(t *testing.T) {
 	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "user", Email: "admin@example.com"}
-	tokens, _ := app.generateTokenPair(&testUser)
+	tokens, _ := app.issueTokenPair(context.Background(), &testUser, "")
 
 	testCookie := &http.Cookie{
 		Name:     "__Host-refresh_token",
@@ -241,8 +406,113 @@ func Test_app_refreshUsingCookie(t *testing.T) {
 	}
 }
 
+func Test_app_refreshTokenRotation(t *testing.T) {
+	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	first, _ := app.issueTokenPair(context.Background(), &testUser, "")
+
+	refreshWithCookie := func(token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/web/refresh-token", nil)
+		req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: token})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the first exchange succeeds, and hands out a different refresh token
+	rr := refreshWithCookie(first.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first refresh: expected status %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var second TokenPairs
+	_ = json.NewDecoder(rr.Body).Decode(&second)
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// replaying the first token is detected as reuse
+	rr = refreshWithCookie(first.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// and the reuse revoked the rest of the family, too
+	rr = refreshWithCookie(second.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token from revoked family: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// tokens that were never recorded are rejected
+	unknown, _ := app.generateTokenPair(&testUser)
+	rr = refreshWithCookie(unknown.RefreshToken)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: expected status %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// failingRefreshRepo is a repository that can't reach its refresh tokens,
+// as when the database is down.
+type failingRefreshRepo struct {
+	repository.DatabaseRepo
+}
+
+func (failingRefreshRepo) GetRefreshToken(ctx context.Context, id string) (*data.RefreshToken, error) {
+	return nil, errors.New("connection refused")
+}
+
+func Test_app_refreshDatabaseError(t *testing.T) {
+	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	tokens, _ := app.issueTokenPair(context.Background(), &testUser, "")
+
+	oldDB := app.DB
+	app.DB = failingRefreshRepo{DatabaseRepo: oldDB}
+	defer func() { app.DB = oldDB }()
+
+	req, _ := http.NewRequest("GET", "/web/refresh-token", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.refreshUsingCookie).ServeHTTP(rr, req)
+
+	// the token may well be valid, so the client must not be told to drop it
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "connection refused") {
+		t.Error("the database error was sent to the client")
+	}
+}
+
+func Test_app_logout(t *testing.T) {
+	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	tokens, _ := app.issueTokenPair(context.Background(), &testUser, "")
+
+	postedData := url.Values{"refresh_token": {tokens.RefreshToken}}
+	req, _ := http.NewRequest("POST", "/logout", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.logout).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("wrong status; expected %d but got %d", http.StatusAccepted, rr.Code)
+	}
+
+	claims, _ := app.parseRefreshToken(tokens.RefreshToken)
+	stored, err := app.DB.GetRefreshToken(context.Background(), claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored.Revoked {
+		t.Error("refresh token was not revoked on logout")
+	}
+}
+
 func Test_app_deleteRefreshCookie(t *testing.T) {
+	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"}
+	tokens, _ := app.issueTokenPair(context.Background(), &testUser, "")
+
 	req, _ := http.NewRequest("GET", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "__Host-refresh_token", Value: tokens.RefreshToken})
 	rr := httptest.NewRecorder()
 	handler := http.HandlerFunc(app.deleteRefreshCookie)
 	handler.ServeHTTP(rr, req)
@@ -265,4 +535,14 @@ func Test_app_deleteRefreshCookie(t *testing.T) {
 		t.Error("__Host-refresh_token cookie not found")
 	}
 
+	claims, _ := app.parseRefreshToken(tokens.RefreshToken)
+	stored, err := app.DB.GetRefreshToken(context.Background(), claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stored.Revoked {
+		t.Error("refresh token was not revoked server side")
+	}
+
 }
@@ -1,6 +1,27 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type contextKey string
+
+const contextClaimsKey contextKey = "claims"
+
+// claimsFromContext returns the verified claims of the caller, as stored by
+// authRequired, or nil if the request has not been authenticated.
+func claimsFromContext(ctx context.Context) *Claims {
+	claims, ok := ctx.Value(contextClaimsKey).(*Claims)
+	if !ok {
+		return nil
+	}
+	return claims
+}
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -18,12 +39,60 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		// expose the caller identity to the handlers further down the chain
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// adminRequired only lets callers whose token carries the admin claim through.
+// It must run after authRequired.
+func (app *application) adminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromContext(r.Context())
+		if claims == nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		if !claims.Admin {
+			app.errorJSON(w, r, errors.New("forbidden"), http.StatusForbidden)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// selfOrAdminRequired lets admins through, and everyone else only when the
+// userID url parameter is their own id. It must run after authRequired.
+func (app *application) selfOrAdminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromContext(r.Context())
+		if claims == nil {
+			app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+			return
+		}
+
+		if !claims.Admin {
+			callerID, err := claims.UserID()
+			if err != nil {
+				app.errorJSON(w, r, errors.New("unauthorized"), http.StatusUnauthorized)
+				return
+			}
+
+			if strconv.Itoa(callerID) != chi.URLParam(r, "userID") {
+				app.errorJSON(w, r, errors.New("forbidden"), http.StatusForbidden)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"simple-web-app/pkg/data"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func Test_app_enableCORS(t *testing.T) {
@@ -78,3 +81,85 @@ func Test_app_authRequired(t *testing.T) {
 		}
 	}
 }
+
+func Test_app_authRequiredAddsClaims(t *testing.T) {
+	testUser := data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1}
+	tokens, _ := app.generateTokenPair(&testUser)
+
+	var claims *Claims
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = claimsFromContext(r.Context())
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	app.authRequired(nextHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	if claims == nil {
+		t.Fatal("claims not found in request context")
+	}
+
+	if claims.Subject != "1" || !claims.Admin {
+		t.Errorf("wrong claims in context; expected subject 1 and admin, got subject %s and admin %t", claims.Subject, claims.Admin)
+	}
+}
+
+func Test_app_adminRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		addClaims      bool
+		admin          bool
+		expectedStatus int
+	}{
+		{"admin", true, true, http.StatusOK},
+		{"not admin", true, false, http.StatusForbidden},
+		{"no claims", false, false, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("DELETE", "/users/2", nil)
+		if test.addClaims {
+			req = addClaimsToReq(req, 1, test.admin)
+		}
+
+		rr := httptest.NewRecorder()
+		app.adminRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_selfOrAdminRequired(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		callerID       int
+		admin          bool
+		paramID        string
+		expectedStatus int
+	}{
+		{"own record", 2, false, "2", http.StatusOK},
+		{"other record", 2, false, "1", http.StatusForbidden},
+		{"admin, other record", 1, true, "2", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/users/"+test.paramID, nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", test.paramID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req = addClaimsToReq(req, test.callerID, test.admin)
+
+		rr := httptest.NewRecorder()
+		app.selfOrAdminRequired(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, rr.Code)
+		}
+	}
+}
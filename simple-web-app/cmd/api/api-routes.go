@@ -22,20 +22,31 @@ func (app *application) routes() http.Handler {
 		r.Get("/logout", app.deleteRefreshCookie)
 	})
 
+	// public keys for verifying our tokens
+	mux.Get("/.well-known/jwks.json", app.jwksHandler)
+
 	// authentication routes - auth handler, refresh token
 	mux.Post("/auth", app.authenticate)
 	mux.Post("/refresh-token", app.refresh)
+	mux.Post("/logout", app.logout)
 
 	// protected routes
 	mux.Route("/users", func(r chi.Router) {
 		// use auth middleware
 		r.Use(app.authRequired)
 
-		r.Get("/", app.allUsers)
-		r.Get("/{userID}", app.getUser)
-		r.Delete("/{userID}", app.deleteUser)
-		r.Put("/", app.insertUser)
+		// users may read their own record; updateUser checks ownership itself
+		r.With(app.selfOrAdminRequired).Get("/{userID}", app.getUser)
 		r.Patch("/", app.updateUser)
+
+		// everything else is reserved for admins
+		r.Group(func(r chi.Router) {
+			r.Use(app.adminRequired)
+
+			r.Get("/", app.allUsers)
+			r.Delete("/{userID}", app.deleteUser)
+			r.Put("/", app.insertUser)
+		})
 	})
 
 	return mux
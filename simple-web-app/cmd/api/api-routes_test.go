@@ -15,6 +15,9 @@ func Test_app_routes(t *testing.T) {
 	}{
 		{route: "/auth", method: "POST"},
 		{route: "/refresh-token", method: "POST"},
+		{route: "/logout", method: "POST"},
+		{route: "/.well-known/jwks.json", method: "GET"},
+		{route: "/web/logout", method: "GET"},
 		{route: "/users/", method: "GET"},
 		{route: "/users/{userID}", method: "GET"},
 		{route: "/users/{userID}", method: "DELETE"},
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,13 +22,33 @@ var refreshTokenExpiry = time.Hour * 24
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+
+	// refreshID and refreshExpiry describe the refresh token, so that it can
+	// be persisted by issueTokenPair
+	refreshID     string
+	refreshExpiry time.Time
 }
 
+var (
+	errRefreshTokenUnknown = errors.New("unknown refresh token")
+	errRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
 type Claims struct {
 	UserName string `json:"name"`
+	Admin    bool   `json:"admin"`
 	jwt.RegisteredClaims
 }
 
+// UserID returns the id of the user the token was issued to.
+func (c *Claims) UserID() (int, error) {
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, errors.New("invalid subject in token")
+	}
+	return id, nil
+}
+
 func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
 	// add a header
 	w.Header().Add("Vary", "Authorization")
@@ -52,16 +77,8 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 	// declare an empty claims var
 	claims := &Claims{}
 
-	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
-		// validate the signing algorithm
-
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-		}
-
-		return []byte(app.JWTSecret), nil
-	})
+	// parse the token; the key set validates the signing algorithm
+	_, err := jwt.ParseWithClaims(token, claims, app.Keys.keyFunc)
 
 	// check for an error, note that this catches expired tokens as well.
 
@@ -82,9 +99,7 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 
 func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 	// Create the token
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = app.Domain
@@ -102,24 +117,148 @@ func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 
 	// create the singed token
 
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	signedAccessToken, err := app.Keys.sign(claims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
-	// create the refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	// create the refresh token, with a unique id so that it can be tracked server side
+	refreshID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	refreshExpiry := time.Now().Add(refreshTokenExpiry)
+
+	refreshTokenClaims := jwt.MapClaims{}
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshTokenClaims["jti"] = refreshID
+	refreshTokenClaims["exp"] = refreshExpiry.Unix()
 
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+	signedRefreshToken, err := app.Keys.sign(refreshTokenClaims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	return TokenPairs{
-		Token:        signedAccessToken,
-		RefreshToken: signedRefreshToken,
+		Token:         signedAccessToken,
+		RefreshToken:  signedRefreshToken,
+		refreshID:     refreshID,
+		refreshExpiry: refreshExpiry,
 	}, nil
 }
+
+// issueTokenPair generates a token pair for user and records the refresh token
+// in the database. An empty familyID starts a new family, i.e. a new login.
+func (app *application) issueTokenPair(ctx context.Context, user *data.User, familyID string) (TokenPairs, error) {
+	tokenPairs, err := app.generateTokenPair(user)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	if familyID == "" {
+		familyID = tokenPairs.refreshID
+	}
+
+	err = app.DB.InsertRefreshToken(ctx, data.RefreshToken{
+		ID:        tokenPairs.refreshID,
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		ExpiresAt: tokenPairs.refreshExpiry,
+	})
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokenPairs, nil
+}
+
+// parseRefreshToken verifies a refresh token and returns its claims.
+func (app *application) parseRefreshToken(refreshToken string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(refreshToken, claims, app.Keys.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// rotateRefreshToken exchanges a verified refresh token for a new token pair,
+// revoking the old refresh token. Presenting a refresh token that has already
+// been exchanged means it has leaked, so the whole family gets revoked and the
+// legitimate holder has to log in again.
+func (app *application) rotateRefreshToken(ctx context.Context, claims *Claims) (TokenPairs, error) {
+	stored, err := app.DB.GetRefreshToken(ctx, claims.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPairs{}, errRefreshTokenUnknown
+	} else if err != nil {
+		return TokenPairs{}, err
+	}
+
+	if stored.Revoked {
+		if err := app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return TokenPairs{}, err
+		}
+		return TokenPairs{}, errRefreshTokenReused
+	}
+
+	userID, err := claims.UserID()
+	if err != nil || userID != stored.UserID {
+		return TokenPairs{}, errRefreshTokenUnknown
+	}
+
+	user, err := app.DB.GetUser(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return TokenPairs{}, errRefreshTokenUnknown
+	} else if err != nil {
+		return TokenPairs{}, err
+	}
+
+	tokenPairs, err := app.issueTokenPair(ctx, user, stored.FamilyID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	// a concurrent request may have exchanged the same token in the meantime
+	ok, err := app.DB.RevokeRefreshToken(ctx, stored.ID, tokenPairs.refreshID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	if !ok {
+		if err := app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return TokenPairs{}, err
+		}
+		return TokenPairs{}, errRefreshTokenReused
+	}
+
+	return tokenPairs, nil
+}
+
+// revokeRefreshToken revokes the family of a refresh token, logging out the
+// session it belongs to. Tokens that can't be verified, or are unknown, are
+// ignored.
+func (app *application) revokeRefreshToken(ctx context.Context, refreshToken string) error {
+	claims, err := app.parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil
+	}
+
+	stored, err := app.DB.GetRefreshToken(ctx, claims.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return app.DB.RevokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// newTokenID returns a random, url safe identifier for a token.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
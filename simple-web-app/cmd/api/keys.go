@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is a key tokens are signed or verified with. Private is nil for
+// keys that are only trusted for verification.
+type signingKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// keySet holds the key new tokens are signed with, and every key a token may
+// have been signed with. Asymmetric keys are identified by the "kid" header of
+// a token; tokens without one were signed with the shared HMAC secret, which
+// only exists when the api signs with it.
+type keySet struct {
+	signing *signingKey
+	hmac    *signingKey
+	verify  map[string]*signingKey
+}
+
+// newKeySet builds the key set for the api. Tokens are signed with the private
+// key in signingKeyFile, or with secret if no key file is given; not both,
+// since anyone who learns the secret could sign tokens the keys are meant to
+// protect. The keys in verifyKeyFiles, typically the public halves of retired
+// signing keys, are accepted for verification only.
+func newKeySet(secret, signingKeyFile string, verifyKeyFiles []string) (*keySet, error) {
+	ks := &keySet{verify: make(map[string]*signingKey)}
+
+	if secret != "" && signingKeyFile != "" {
+		return nil, errors.New("a jwt secret can't be used together with a signing key")
+	}
+
+	if secret != "" {
+		ks.hmac = &signingKey{
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		}
+		ks.signing = ks.hmac
+	}
+
+	if signingKeyFile != "" {
+		key, err := loadKeyFile(signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key.Private == nil {
+			return nil, fmt.Errorf("%s does not contain a private key", signingKeyFile)
+		}
+		ks.signing = key
+		ks.verify[key.ID] = key
+	}
+
+	for _, file := range verifyKeyFiles {
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// never sign with a key that is only meant for verification
+		key.Private = nil
+		if _, exists := ks.verify[key.ID]; !exists {
+			ks.verify[key.ID] = key
+		}
+	}
+
+	if ks.signing == nil {
+		return nil, errors.New("either a jwt secret or a signing key is required")
+	}
+
+	return ks, nil
+}
+
+// sign returns claims as a token signed with the current signing key.
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+	return token.SignedString(ks.signing.Private)
+}
+
+// keyFunc picks the verification key for a token, by its kid header, and makes
+// sure the token uses the algorithm that belongs to that key.
+func (ks *keySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	var key *signingKey
+
+	if kid, ok := t.Header["kid"].(string); ok && kid != "" {
+		key = ks.verify[kid]
+		if key == nil {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+	} else {
+		key = ks.hmac
+		if key == nil {
+			return nil, errors.New("token has no key id")
+		}
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+// jwk is the JSON Web Key representation of a public key (RFC 7517).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// jwks returns the public keys of the set. The HMAC secret is never published.
+func (ks *keySet) jwks() []jwk {
+	keys := []jwk{}
+	for _, key := range ks.verify {
+		k := publicJWK(key.Public)
+		k.KeyID = key.ID
+		k.Use = "sig"
+		k.Algorithm = key.Method.Alg()
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
+
+// publicJWK returns the key type specific members of the JWK for pub.
+func publicJWK(pub interface{}) jwk {
+	enc := base64.RawURLEncoding
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			KeyType: "RSA",
+			N:       enc.EncodeToString(pub.N.Bytes()),
+			E:       enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return jwk{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       enc.EncodeToString(pub),
+		}
+	}
+	return jwk{}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of pub, which serves as the
+// key id. It only depends on the key, so every service derives the same id.
+func thumbprint(pub interface{}) (string, error) {
+	k := publicJWK(pub)
+
+	var members string
+	switch k.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":%q}`, k.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %T", pub)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// loadKeyFile reads an RSA or Ed25519 key, private or public, from a PEM file.
+func loadKeyFile(path string) (*signingKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &signingKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+
+	key.ID, err = thumbprint(key.Public)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// jwksHandler publishes the public verification keys, so that other services
+// can verify our tokens without sharing a secret.
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, app.Keys.jwks(), "keys")
+}
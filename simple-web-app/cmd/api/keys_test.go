@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simple-web-app/pkg/data"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeKeyFiles generates a key pair and writes both halves as PEM files,
+// returning the paths of the private and the public key.
+func writeKeyFiles(t *testing.T, name string, private interface{}, public interface{}) (string, string) {
+	dir := t.TempDir()
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privatePath := filepath.Join(dir, name+".pem")
+	publicPath := filepath.Join(dir, name+".pub.pem")
+
+	err = os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privatePath, publicPath
+}
+
+func Test_keySet_signAndVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPrivate, rsaPublic := writeKeyFiles(t, "rsa", rsaKey, &rsaKey.PublicKey)
+
+	edPublicKey, edPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	edPrivate, edPublic := writeKeyFiles(t, "ed25519", edPrivateKey, edPublicKey)
+
+	var tests = []struct {
+		name       string
+		signingKey string
+		alg        string
+	}{
+		{"rsa", rsaPrivate, "RS256"},
+		{"ed25519", edPrivate, "EdDSA"},
+	}
+
+	for _, test := range tests {
+		ks, err := newKeySet("", test.signingKey, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		signed, err := ks.sign(jwt.MapClaims{"sub": "1"})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		token, err := jwt.Parse(signed, ks.keyFunc)
+		if err != nil {
+			t.Errorf("%s: token did not verify: %s", test.name, err)
+			continue
+		}
+
+		if token.Header["alg"] != test.alg {
+			t.Errorf("%s: expected alg %s, got %v", test.name, test.alg, token.Header["alg"])
+		}
+
+		if token.Header["kid"] != ks.signing.ID {
+			t.Errorf("%s: expected kid %s, got %v", test.name, ks.signing.ID, token.Header["kid"])
+		}
+	}
+
+	// rotate from the rsa key to the ed25519 key, keeping the rsa key for verification
+	oldKeys, _ := newKeySet("", rsaPrivate, nil)
+	oldToken, _ := oldKeys.sign(jwt.MapClaims{"sub": "1"})
+
+	newKeys, err := newKeySet("", edPrivate, []string{rsaPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.Parse(oldToken, newKeys.keyFunc); err != nil {
+		t.Errorf("token signed with the retired key did not verify: %s", err)
+	}
+
+	// once the rsa key is dropped, its tokens are rejected
+	newKeys, _ = newKeySet("", edPrivate, []string{edPublic})
+	if _, err := jwt.Parse(oldToken, newKeys.keyFunc); err == nil {
+		t.Error("token signed with an unknown key verified")
+	}
+
+	// HMAC tokens are rejected once there is no secret
+	hmacKeys, _ := newKeySet("secret", "", nil)
+	hmacToken, _ := hmacKeys.sign(jwt.MapClaims{"sub": "1"})
+	if _, err := jwt.Parse(hmacToken, newKeys.keyFunc); err == nil {
+		t.Error("HMAC token verified without a secret")
+	}
+
+	// an HMAC token claiming to be signed by the rsa key is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"})
+	forged.Header["kid"] = oldKeys.signing.ID
+	forgedToken, _ := forged.SignedString([]byte("secret"))
+	if _, err := jwt.Parse(forgedToken, oldKeys.keyFunc); err == nil {
+		t.Error("token with mismatched algorithm verified")
+	}
+
+	// with a signing key, tokens without a key id are rejected, so that
+	// nobody can sign an HMAC token with a secret they got hold of
+	kidless, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1", "admin": true}).SignedString([]byte("secret"))
+	if _, err := jwt.Parse(kidless, oldKeys.keyFunc); err == nil {
+		t.Error("HMAC token without a key id verified although a key file is configured")
+	}
+
+	// and a secret can't be configured next to a signing key
+	if _, err := newKeySet("secret", rsaPrivate, nil); err == nil {
+		t.Error("expected an error when combining a secret with a signing key")
+	}
+
+	// a public key can't be used for signing
+	if _, err := newKeySet("", rsaPublic, nil); err == nil {
+		t.Error("expected an error when signing with a public key")
+	}
+}
+
+func Test_app_jwksHandler(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPrivate, _ := writeKeyFiles(t, "rsa", rsaKey, &rsaKey.PublicKey)
+
+	edPublicKey, edPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, edPublic := writeKeyFiles(t, "ed25519", edPrivateKey, edPublicKey)
+
+	oldKeys := app.Keys
+	defer func() { app.Keys = oldKeys }()
+
+	var err error
+	app.Keys, err = newKeySet("", rsaPrivate, []string{edPublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.jwksHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong status; expected %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&set); err != nil {
+		t.Fatal(err)
+	}
+
+	// the HMAC secret must never be published
+	if len(set.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(set.Keys))
+	}
+
+	for _, k := range set.Keys {
+		switch k.KeyType {
+		case "RSA":
+			if k.N == "" || k.E == "" || k.Algorithm != "RS256" {
+				t.Errorf("incomplete RSA key: %+v", k)
+			}
+		case "OKP":
+			if k.X == "" || k.Curve != "Ed25519" || k.Algorithm != "EdDSA" {
+				t.Errorf("incomplete Ed25519 key: %+v", k)
+			}
+		default:
+			t.Errorf("unexpected key type %s", k.KeyType)
+		}
+	}
+
+	// tokens issued by the api verify against the published key
+	tokens, err := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := new(jwt.Parser).ParseUnverified(tokens.Token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token.Header["kid"] != app.Keys.signing.ID {
+		t.Errorf("access token not signed with the signing key; kid %v", token.Header["kid"])
+	}
+}
+
+func Test_thumbprint(t *testing.T) {
+	// the example key from RFC 7638, section 3.1
+	n := "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	e := "AQAB"
+
+	nBytes, _ := base64.RawURLEncoding.DecodeString(n)
+	eBytes, _ := base64.RawURLEncoding.DecodeString(e)
+
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}
+
+	kid, err := thumbprint(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("wrong thumbprint: %s", kid)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"simple-web-app/pkg/repository"
 	"simple-web-app/pkg/repository/dbrepo"
 )
@@ -16,21 +17,54 @@ type application struct {
 	DB        repository.DatabaseRepo
 	Domain    string
 	JWTSecret string
+	Keys      *keySet
 }
 
 func main() {
 	var app application
 	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "2dce505d96a53c5768052ee90f3df2055657518dad489160df9913f66042e160", "signing secret")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", os.Getenv("JWT_SECRET"), "secret to sign tokens with, defaults to $JWT_SECRET; required unless -jwt-key is given")
+	jwtKey := flag.String("jwt-key", "", "PEM file with the RSA or Ed25519 private key to sign tokens with, instead of a secret")
+	jwtVerifyKeys := flag.String("jwt-verify-keys", "", "comma separated PEM files with additional keys to accept tokens from, e.g. retired signing keys")
+	migrate := flag.Bool("migrate", false, "apply pending database migrations at startup")
+	dbType := flag.String("db", "postgres", "database to use: postgres, or memory to run without Postgres")
+	fixtures := flag.String("fixtures", "", "JSON file to seed the in-memory database with, e.g. ./fixtures/users.json")
 	flag.Parse()
 
-	conn, err := app.connectToDB()
+	keys, err := newKeySet(app.JWTSecret, *jwtKey, splitList(*jwtVerifyKeys))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Keys = keys
+
+	switch *dbType {
+	case "memory":
+		repo := dbrepo.NewMemoryDBRepo()
+		if *fixtures != "" {
+			if err := repo.LoadFixtures(*fixtures); err != nil {
+				log.Fatal(err)
+			}
+		}
+		app.DB = repo
+		log.Println("Using in-memory database")
+	case "postgres":
+		conn, err := app.connectToDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		if *migrate {
+			if err := migrateDB(conn); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	default:
+		log.Fatalf("unknown database %q", *dbType)
+	}
 
 	log.Printf("Starting api on port %d\n", port)
 	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
@@ -3,8 +3,14 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
+	"simple-web-app/pkg/repository"
+	"simple-web-app/pkg/validator"
+	"strings"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,12 +48,115 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
+// Problem is an RFC 7807 problem details object, sent instead of the plain
+// error envelope to clients that accept application/problem+json.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError explains why a single field of a request was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// problemTypes lists the problems with a type of their own. Any other error
+// is reported as about:blank, titled with the status text.
+var problemTypes = []struct {
+	err   error
+	name  string
+	title string
+}{
+	{repository.ErrValidation, "validation", "The request contains invalid values"},
+	{repository.ErrNotFound, "not-found", "The requested record does not exist"},
+	{repository.ErrDuplicateEmail, "duplicate-email", "The email address is already in use"},
+	{repository.ErrConflict, "conflict", "The request conflicts with an existing record"},
+	{errRefreshTokenUnknown, "refresh-token-unknown", "The refresh token is unknown"},
+	{errRefreshTokenReused, "refresh-token-reused", "The refresh token has already been used"},
+}
+
+// problem describes err, sent with status in response to r, as a Problem.
+func (app *application) problem(r *http.Request, err error, status int) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+
+	for _, pt := range problemTypes {
+		if errors.Is(err, pt.err) {
+			p.Type = fmt.Sprintf("https://%s/problems/%s", app.Domain, pt.name)
+			p.Title = pt.title
+			break
+		}
+	}
+
+	var validationErr *repository.ValidationError
+	var validationErrs validator.Errors
+	switch {
+	case errors.As(err, &validationErr):
+		p.Errors = []FieldError{{Field: validationErr.Field, Message: validationErr.Message}}
+	case errors.As(err, &validationErrs):
+		p.Type = fmt.Sprintf("https://%s/problems/validation", app.Domain)
+		p.Title = "The request contains invalid values"
+		p.Errors = fieldErrors(validationErrs)
+	}
+
+	return p
+}
+
+// fieldErrors flattens errs into a list ordered by field name.
+func fieldErrors(errs validator.Errors) []FieldError {
+	var list []FieldError
+	for _, field := range errs.Fields() {
+		for _, message := range errs[field] {
+			list = append(list, FieldError{Field: field, Message: message})
+		}
+	}
+	return list
+}
+
+// acceptsProblemJSON reports whether the client asked for
+// application/problem+json in its Accept header.
+func acceptsProblemJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/problem+json" {
+			return true
+		}
+	}
+	return false
+}
+
+// errorJSON sends err with the given status, 400 by default. Clients that
+// accept application/problem+json get a Problem, everyone else the original
+// {"error":{"message":...}} envelope.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
 	statusCode := http.StatusBadRequest
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
+	if acceptsProblemJSON(r) {
+		out, err := json.Marshal(app.problem(r, err, statusCode))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(out)
+		return
+	}
+
 	type jsonError struct {
 		Message string `json:"message"`
 	}
@@ -59,6 +168,34 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 	_ = app.writeJSON(w, statusCode, theError, "error")
 }
 
+// errorStatus maps the errors of the repository package to HTTP status codes.
+// Anything else is a server error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrDuplicateEmail), errors.Is(err, repository.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrValidation):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// dbErrorJSON reports an error returned by the repository, with the status
+// errorStatus picks for it. Server errors are logged rather than sent, as they
+// may come straight from the database driver.
+func (app *application) dbErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Println(err)
+		err = errors.New(http.StatusText(status))
+	}
+
+	app.errorJSON(w, r, err, status)
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	maxBytes := 1024 * 1024 // one megabyte
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
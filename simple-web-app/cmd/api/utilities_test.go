@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"simple-web-app/pkg/repository"
+	"simple-web-app/pkg/validator"
+	"strings"
+	"testing"
+)
+
+func Test_acceptsProblemJSON(t *testing.T) {
+	var tests = []struct {
+		name     string
+		accept   string
+		expected bool
+	}{
+		{"no header", "", false},
+		{"json", "application/json", false},
+		{"problem json", "application/problem+json", true},
+		{"with parameters", "application/json;q=0.9, application/problem+json; charset=utf-8", true},
+		{"anything", "*/*", false},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+
+		if acceptsProblemJSON(req) != test.expected {
+			t.Errorf("%s: expected %t", test.name, test.expected)
+		}
+	}
+}
+
+func Test_app_errorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		status         int
+		expectedType   string
+		expectedTitle  string
+		expectedErrors []FieldError
+	}{
+		{"plain error", errors.New("forbidden"), http.StatusForbidden, "about:blank", "Forbidden", nil},
+		{"not found", repository.ErrNotFound, http.StatusNotFound, "https://example.com/problems/not-found", "The requested record does not exist", nil},
+		{"wrapped duplicate email", fmt.Errorf("%w: jack@example.com", repository.ErrDuplicateEmail), http.StatusConflict, "https://example.com/problems/duplicate-email", "The email address is already in use", nil},
+		{
+			"validation error",
+			&repository.ValidationError{Field: "email", Message: "must not be empty"},
+			http.StatusUnprocessableEntity,
+			"https://example.com/problems/validation",
+			"The request contains invalid values",
+			[]FieldError{{Field: "email", Message: "must not be empty"}},
+		},
+		{
+			"validator errors",
+			validator.Errors{"last_name": {"This field cannot be blank"}, "email": {"Invalid email address"}},
+			http.StatusUnprocessableEntity,
+			"https://example.com/problems/validation",
+			"The request contains invalid values",
+			[]FieldError{{Field: "email", Message: "Invalid email address"}, {Field: "last_name", Message: "This field cannot be blank"}},
+		},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.Header.Set("Accept", "application/problem+json")
+		rr := httptest.NewRecorder()
+
+		app.errorJSON(rr, req, test.err, test.status)
+
+		if rr.Code != test.status {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.status, rr.Code)
+		}
+
+		if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: expected content type application/problem+json but got %s", test.name, ct)
+		}
+
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatalf("%s: could not decode problem: %s", test.name, err)
+		}
+
+		if p.Type != test.expectedType {
+			t.Errorf("%s: expected type %s but got %s", test.name, test.expectedType, p.Type)
+		}
+
+		if p.Title != test.expectedTitle {
+			t.Errorf("%s: expected title %q but got %q", test.name, test.expectedTitle, p.Title)
+		}
+
+		if p.Status != test.status || p.Detail != test.err.Error() || p.Instance != "/users/1" {
+			t.Errorf("%s: wrong status, detail or instance: %+v", test.name, p)
+		}
+
+		if len(p.Errors) != len(test.expectedErrors) {
+			t.Errorf("%s: expected %d field errors but got %d", test.name, len(test.expectedErrors), len(p.Errors))
+			continue
+		}
+
+		for i, fe := range p.Errors {
+			if fe != test.expectedErrors[i] {
+				t.Errorf("%s: expected field error %+v but got %+v", test.name, test.expectedErrors[i], fe)
+			}
+		}
+	}
+}
+
+func Test_app_errorJSONDefaultFormat(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+
+	app.errorJSON(rr, req, errors.New("bad"))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d but got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected content type application/json but got %s", ct)
+	}
+
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"error":{"message":"bad"}}` {
+		t.Errorf("unexpected body %s", body)
+	}
+}
+
+func Test_app_dbErrorJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedDetail string
+	}{
+		{"not found", repository.ErrNotFound, http.StatusNotFound, repository.ErrNotFound.Error()},
+		{"duplicate email", repository.ErrDuplicateEmail, http.StatusConflict, repository.ErrDuplicateEmail.Error()},
+		{"conflict", repository.ErrConflict, http.StatusConflict, repository.ErrConflict.Error()},
+		{"validation", &repository.ValidationError{Field: "email", Message: "must not be empty"}, http.StatusUnprocessableEntity, "email must not be empty"},
+		{"driver error", errors.New(`pq: relation "users" does not exist`), http.StatusInternalServerError, "Internal Server Error"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "application/problem+json")
+		rr := httptest.NewRecorder()
+
+		app.dbErrorJSON(rr, req, test.err)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+
+		var p Problem
+		_ = json.NewDecoder(rr.Body).Decode(&p)
+		if p.Detail != test.expectedDetail {
+			t.Errorf("%s: expected detail %q but got %q", test.name, test.expectedDetail, p.Detail)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -16,15 +17,20 @@ type application struct {
 
 // This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
 // the token that is printed out.
-// go run ./cmd/cli -action=valid     // will produce a valid token
-// go run ./cmd/cli -action=expired   // will produce an expired token
+// The secret has to be the one the api is started with.
+// go run ./cmd/cli -jwt-secret=... -action=valid     // will produce a valid token
+// go run ./cmd/cli -jwt-secret=... -action=expired   // will produce an expired token
 
 func main() {
 	var app application
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "2dce505d96a53c5768052ee90f3df2055657518dad489160df9913f66042e160", "secret")
+	flag.StringVar(&app.JWTSecret, "jwt-secret", os.Getenv("JWT_SECRET"), "secret the api signs tokens with, defaults to $JWT_SECRET")
 	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
 	flag.Parse()
 
+	if app.JWTSecret == "" {
+		log.Fatal("a jwt secret is required")
+	}
+
 	// generate a token
 	token := jwt.New(jwt.SigningMethodHS256)
 
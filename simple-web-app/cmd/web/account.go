@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/mailer"
+	"simple-web-app/pkg/repository"
+	"strings"
+	"time"
+)
+
+var verifyEmailTokenExpiry = 24 * time.Hour
+var passwordResetTokenExpiry = time.Hour
+
+// sendTokenEmail issues a token of the given scope to user, replacing any
+// they had before, and emails them a link to path carrying it.
+func (app *application) sendTokenEmail(ctx context.Context, user *data.User, scope string, ttl time.Duration, path, subject, text string) error {
+	err := app.DB.DeleteTokensForUser(ctx, scope, user.ID)
+	if err != nil {
+		return err
+	}
+
+	token, err := data.GenerateToken(user.ID, ttl, scope)
+	if err != nil {
+		return err
+	}
+
+	err = app.DB.InsertToken(ctx, *token)
+	if err != nil {
+		return err
+	}
+
+	link := app.BaseURL + path + "?token=" + url.QueryEscape(token.Plaintext)
+
+	return app.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hi %s,\n\n%s\n\n%s\n\nThe link expires in %s.\n", user.FirstName, text, link, ttl),
+	})
+}
+
+// sendVerificationEmail emails user a link that confirms their address.
+func (app *application) sendVerificationEmail(ctx context.Context, user *data.User) error {
+	return app.sendTokenEmail(ctx, user, data.ScopeVerifyEmail, verifyEmailTokenExpiry, "/verify-email",
+		"Confirm your email address",
+		"please confirm your email address by opening this link:")
+}
+
+// VerifyEmail marks the address of the user a verification link was sent to
+// as verified. Links work once, whether or not the user is logged in.
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	userID, err := app.DB.ConsumeToken(r.Context(), data.ScopeVerifyEmail, data.HashToken(token))
+	if stderrors.Is(err, repository.ErrNotFound) {
+		app.Session.Put(r.Context(), "error", "This link is invalid or has expired")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.MarkEmailVerified(r.Context(), userID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your email address has been verified")
+
+	if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok && user.ID == userID {
+		user.Verified = true
+		app.Session.Put(r.Context(), "user", user)
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ResendVerificationEmail sends the logged in user a new verification link.
+func (app *application) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	if user.Verified {
+		app.Session.Put(r.Context(), "flash", "Your email address is verified already")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	err := app.sendVerificationEmail(r.Context(), &user)
+	if err != nil {
+		log.Println("could not send verification email:", err)
+		app.Session.Put(r.Context(), "error", "We could not send you an email, please try again later")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "We have sent you a new link to confirm your email address")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// ForgotPasswordPage shows the form to ask for a password reset link.
+func (app *application) ForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "forgot-password.page.gohtml", &TemplateData{Form: NewForm(nil)})
+}
+
+// ForgotPassword emails a password reset link to the address in the form, if
+// it belongs to a user. The response is the same either way, so the form
+// can't be used to find out who has an account.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	form.Email("email")
+	if !form.Valid() {
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "forgot-password.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), form.Data.Get("email"))
+	if err != nil && !stderrors.Is(err, repository.ErrNotFound) {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if err == nil {
+		err = app.sendTokenEmail(r.Context(), user, data.ScopePasswordReset, passwordResetTokenExpiry, "/reset-password",
+			"Reset your password",
+			"someone asked to reset the password of your account. If that was you, choose a new password here:")
+		if err != nil {
+			log.Println("could not send password reset email:", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If an account with that email address exists, we have sent it a link to reset the password")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ResetPasswordPage shows the form to choose a new password, carrying the
+// token from the link along.
+func (app *application) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	form := NewForm(url.Values{"token": {r.URL.Query().Get("token")}})
+	_ = app.render(w, r, "reset-password.page.gohtml", &TemplateData{Form: form})
+}
+
+// ResetPassword sets a new password for the user a reset link was sent to.
+// Since the link proves they can read the mail, it verifies the address too.
+// The reset may be because someone else got into the account, so the user is
+// logged out everywhere.
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("token", "password", "password_confirmation")
+	checkNewPassword(form)
+	if !form.Valid() {
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "reset-password.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	userID, err := app.DB.ConsumeToken(r.Context(), data.ScopePasswordReset, data.HashToken(form.Data.Get("token")))
+	if stderrors.Is(err, repository.ErrNotFound) {
+		app.Session.Put(r.Context(), "error", "This link is invalid or has expired, please ask for a new one")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), userID, form.Data.Get("password"))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.MarkEmailVerified(r.Context(), userID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.logOutEverywhere(r.Context(), userID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your password has been changed, please log in")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// logOutEverywhere ends the sessions of userID, except for the one in ctx, and
+// revokes the refresh tokens they use the api with, once their password
+// changed.
+func (app *application) logOutEverywhere(ctx context.Context, userID int) error {
+	_, err := app.destroyUserSessions(ctx, userID, func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	return app.DB.RevokeUserRefreshTokens(ctx, userID)
+}
+
+// checkUserDetails validates the name and email address fields of a form.
+func checkUserDetails(form *Form) {
+	form.Required("first_name", "last_name", "email")
+	form.MaxLength("first_name", 255)
+	form.MaxLength("last_name", 255)
+	form.Email("email")
+	form.MaxLength("email", 255)
+}
+
+// checkNewPassword validates the password and password_confirmation fields
+// of a form that sets a password.
+func checkNewPassword(form *Form) {
+	form.MinLength("password", 8)
+	// bcrypt only looks at the first 72 bytes
+	form.Check(len(form.Data.Get("password")) <= 72, "password", "This field must be at most 72 bytes long")
+	form.Matches("password_confirmation", "password")
+}
+
+// EditProfilePage shows the form to change the name and email address of the
+// logged in user.
+func (app *application) EditProfilePage(w http.ResponseWriter, r *http.Request) {
+	user, err := app.DB.GetUser(r.Context(), app.sessionUserID(r.Context()))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	form := NewForm(url.Values{
+		"first_name": {user.FirstName},
+		"last_name":  {user.LastName},
+		"email":      {user.Email},
+	})
+	_ = app.render(w, r, "edit-profile.page.gohtml", &TemplateData{Form: form})
+}
+
+// EditProfile saves the name and email address of the logged in user, who
+// confirms the change with their password. A new address is unverified until
+// the user opens the link we send to it.
+func (app *application) EditProfile(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	checkUserDetails(form)
+	form.Required("current_password")
+	if !form.Valid() {
+		app.renderAccountForm(w, r, "edit-profile.page.gohtml", form)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), app.sessionUserID(r.Context()))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if !app.authenticate(r, user, form.Data.Get("current_password")) {
+		form.Errors.Add("current_password", "Your password is incorrect")
+		app.renderAccountForm(w, r, "edit-profile.page.gohtml", form)
+		return
+	}
+
+	emailChanged := !strings.EqualFold(user.Email, form.Data.Get("email"))
+	user.FirstName = form.Data.Get("first_name")
+	user.LastName = form.Data.Get("last_name")
+	user.Email = form.Data.Get("email")
+
+	err = app.DB.UpdateUser(r.Context(), *user)
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "An account with this email address already exists")
+		app.renderAccountForm(w, r, "edit-profile.page.gohtml", form)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.refreshSessionUser(r.Context())
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if !emailChanged {
+		app.Session.Put(r.Context(), "flash", "Your profile has been saved")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	// like at sign up, the user can ask for another link if this one fails
+	err = app.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		log.Println("could not send verification email:", err)
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your profile has been saved, please confirm your new email address with the link we sent to it")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// ChangePasswordPage shows the form to change the password of the logged in
+// user.
+func (app *application) ChangePasswordPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "change-password.page.gohtml", &TemplateData{Form: NewForm(nil)})
+}
+
+// ChangePassword sets a new password for the logged in user, who confirms it
+// with their current one. Whoever is logged in as the user elsewhere, or has
+// a reset link, may know the old password, so those sessions, api tokens and
+// links end.
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("current_password", "password", "password_confirmation")
+	checkNewPassword(form)
+	if !form.Valid() {
+		app.renderAccountForm(w, r, "change-password.page.gohtml", form)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), app.sessionUserID(r.Context()))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if !app.authenticate(r, user, form.Data.Get("current_password")) {
+		form.Errors.Add("current_password", "Your password is incorrect")
+		app.renderAccountForm(w, r, "change-password.page.gohtml", form)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), user.ID, form.Data.Get("password"))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.DeleteTokensForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.logOutEverywhere(r.Context(), user.ID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your password has been changed")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// renderAccountForm shows one of the account forms again, with the errors of
+// form.
+func (app *application) renderAccountForm(w http.ResponseWriter, r *http.Request, t string, form *Form) {
+	_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, t, &TemplateData{Form: form})
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"simple-web-app/pkg/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+var tokenInMail = regexp.MustCompile(`\?token=([A-Z0-9]+)`)
+
+// mailedToken returns the token in the link of the last email sent to email.
+func mailedToken(t *testing.T, email string) string {
+	msg, ok := mail.last()
+	if !ok || msg.To != email {
+		t.Fatalf("no email was sent to %s", email)
+	}
+
+	match := tokenInMail.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("no token in email: %s", msg.Body)
+	}
+	return match[1]
+}
+
+func Test_app_VerifyEmail(t *testing.T) {
+	resetDB()
+	defer resetDB()
+
+	id, _ := app.DB.InsertUser(context.Background(), data.User{FirstName: "John", LastName: "Doe", Email: "john@example.com", Password: "secret"})
+	user, _ := app.DB.GetUser(context.Background(), id)
+
+	if err := app.sendVerificationEmail(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	token := mailedToken(t, "john@example.com")
+
+	var tests = []struct {
+		name             string
+		token            string
+		expectedLoc      string
+		expectedVerified bool
+	}{
+		{"invalid token", "NOTATOKEN", "/", false},
+		{"valid token", token, "/", true},
+		{"used token", token, "/", true},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/verify-email?token="+test.token, nil)
+		req = addContextAndSessionToReq(req, app)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.VerifyEmail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected status %d, but got %d", test.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != test.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %s", test.name, test.expectedLoc, loc)
+		}
+
+		user, _ = app.DB.GetUser(context.Background(), id)
+		if user.Verified != test.expectedVerified {
+			t.Errorf("%s: expected verified to be %t", test.name, test.expectedVerified)
+		}
+	}
+}
+
+func Test_app_ResendVerificationEmail(t *testing.T) {
+	var tests = []struct {
+		name         string
+		user         data.User
+		expectedMail bool
+	}{
+		{"unverified", data.User{ID: 2, FirstName: "Jack", Email: "jack@example.com"}, true},
+		{"verified", data.User{ID: 1, FirstName: "Admin", Email: "admin@example.com", Verified: true}, false},
+	}
+
+	for _, test := range tests {
+		mail.sent = nil
+
+		req, _ := http.NewRequest("POST", "/user/verify-email", nil)
+		req = addContextAndSessionToReq(req, app)
+		app.Session.Put(req.Context(), "user", test.user)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.ResendVerificationEmail)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/user/profile" {
+			t.Errorf("%s: expected location /user/profile, but got %s", test.name, loc)
+		}
+
+		if _, sent := mail.last(); sent != test.expectedMail {
+			t.Errorf("%s: expected email to be sent to be %t", test.name, test.expectedMail)
+		}
+	}
+}
+
+func Test_app_ForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedMail       bool
+	}{
+		{"existing user", "admin@example.com", http.StatusSeeOther, true},
+		{"unknown user", "nobody@example.com", http.StatusSeeOther, false},
+		{"invalid email", "admin", http.StatusUnprocessableEntity, false},
+		{"missing email", "", http.StatusUnprocessableEntity, false},
+	}
+
+	for _, test := range tests {
+		mail.sent = nil
+
+		postedData := url.Values{"email": {test.email}}
+		req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToReq(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.ForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		msg, sent := mail.last()
+		if sent != test.expectedMail {
+			t.Errorf("%s: expected email to be sent to be %t", test.name, test.expectedMail)
+		}
+
+		if sent && !strings.Contains(msg.Body, "http://localhost:8080/reset-password?token=") {
+			t.Errorf("%s: no reset link in email: %s", test.name, msg.Body)
+		}
+	}
+}
+
+func Test_app_ResetPassword(t *testing.T) {
+	resetDB()
+	defer resetDB()
+
+	postedData := url.Values{"email": {"jack@example.com"}}
+	req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToReq(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	http.HandlerFunc(app.ForgotPassword).ServeHTTP(httptest.NewRecorder(), req)
+
+	token := mailedToken(t, "jack@example.com")
+
+	// whoever is logged in as jack, on the web or the api, is logged out
+	elsewhere := storeUserSession(t, data.User{ID: 2}, "10.0.0.2")
+	err := app.DB.InsertRefreshToken(context.Background(), data.RefreshToken{ID: "jack", UserID: 2, FamilyID: "jack", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLoc        string
+	}{
+		{
+			name:               "passwords differ",
+			postedData:         url.Values{"token": {token}, "password": {"new password"}, "password_confirmation": {"other password"}},
+			expectedStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:               "invalid token",
+			postedData:         url.Values{"token": {"NOTATOKEN"}, "password": {"new password"}, "password_confirmation": {"new password"}},
+			expectedStatusCode: http.StatusSeeOther, expectedLoc: "/forgot-password",
+		},
+		{
+			name:               "valid",
+			postedData:         url.Values{"token": {token}, "password": {"new password"}, "password_confirmation": {"new password"}},
+			expectedStatusCode: http.StatusSeeOther, expectedLoc: "/",
+		},
+		{
+			name:               "used token",
+			postedData:         url.Values{"token": {token}, "password": {"another password"}, "password_confirmation": {"another password"}},
+			expectedStatusCode: http.StatusSeeOther, expectedLoc: "/forgot-password",
+		},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(test.postedData.Encode()))
+		req = addContextAndSessionToReq(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.ResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != test.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %s", test.name, test.expectedLoc, loc)
+		}
+	}
+
+	user, _ := app.DB.GetUser(context.Background(), 2)
+	if matches, _ := user.PasswordMatches("new password"); !matches {
+		t.Error("password was not changed")
+	}
+
+	if sessionExists(elsewhere) {
+		t.Error("the other session of the user was not ended")
+	}
+
+	if stored, _ := app.DB.GetRefreshToken(context.Background(), "jack"); !stored.Revoked {
+		t.Error("the refresh token of the user was not revoked")
+	}
+}
+
+// postAsUser posts postedData to handler, logged in as userID, and returns
+// the response and the request, whose session has the flash messages.
+func postAsUser(handler http.HandlerFunc, userID int, postedData url.Values) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest("POST", "/user/profile", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToReq(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	user, _ := app.DB.GetUser(context.Background(), userID)
+	app.Session.Put(req.Context(), "user", *user)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, req
+}
+
+func Test_app_EditProfilePage(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/profile/edit", nil)
+	req = addContextAndSessionToReq(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 2})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.EditProfilePage).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), `value="jack@example.com"`) {
+		t.Error("the form is not filled in with the user's details")
+	}
+}
+
+func Test_app_EditProfile(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedEmail      string
+		expectedVerified   bool
+		expectedMail       bool
+	}{
+		{
+			name:               "new name",
+			postedData:         url.Values{"first_name": {"Jacky"}, "last_name": {"Smith"}, "email": {"jack@example.com"}, "current_password": {"secret"}},
+			expectedStatusCode: http.StatusSeeOther, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name:               "new email",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jacky@example.com"}, "current_password": {"secret"}},
+			expectedStatusCode: http.StatusSeeOther, expectedEmail: "jacky@example.com", expectedMail: true,
+		},
+		{
+			name:               "wrong password",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jacky@example.com"}, "current_password": {"wrong"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name:               "no password",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jacky@example.com"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name:               "invalid email",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jack"}, "current_password": {"secret"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name:               "email of another user",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"admin@example.com"}, "current_password": {"secret"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		mail.sent = nil
+
+		rr, req := postAsUser(app.EditProfile, 2, test.postedData)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		if user.Email != test.expectedEmail || user.Verified != test.expectedVerified {
+			t.Errorf("%s: expected email %s, verified %t, but got %s, %t", test.name, test.expectedEmail, test.expectedVerified, user.Email, user.Verified)
+		}
+
+		if _, sent := mail.last(); sent != test.expectedMail {
+			t.Errorf("%s: expected email to be sent to be %t", test.name, test.expectedMail)
+		}
+
+		if test.expectedStatusCode != http.StatusSeeOther {
+			continue
+		}
+
+		if sessionUser, ok := app.Session.Get(req.Context(), "user").(data.User); !ok || sessionUser.FirstName != user.FirstName || sessionUser.Email != user.Email {
+			t.Errorf("%s: the session user was not refreshed", test.name)
+		}
+
+		if test.expectedMail {
+			mailedToken(t, test.expectedEmail)
+		}
+	}
+
+	resetDB()
+}
+
+func Test_app_ChangePassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedPassword   string
+	}{
+		{
+			name:               "valid",
+			postedData:         url.Values{"current_password": {"secret"}, "password": {"new password"}, "password_confirmation": {"new password"}},
+			expectedStatusCode: http.StatusSeeOther, expectedPassword: "new password",
+		},
+		{
+			name:               "wrong password",
+			postedData:         url.Values{"current_password": {"wrong"}, "password": {"new password"}, "password_confirmation": {"new password"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedPassword: "secret",
+		},
+		{
+			name:               "passwords differ",
+			postedData:         url.Values{"current_password": {"secret"}, "password": {"new password"}, "password_confirmation": {"other password"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedPassword: "secret",
+		},
+		{
+			name:               "too short",
+			postedData:         url.Values{"current_password": {"secret"}, "password": {"short"}, "password_confirmation": {"short"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedPassword: "secret",
+		},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		other := storeUserSession(t, data.User{ID: 2}, "10.0.0.2")
+
+		rr, _ := postAsUser(app.ChangePassword, 2, test.postedData)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		if matches, _ := user.PasswordMatches(test.expectedPassword); !matches {
+			t.Errorf("%s: expected the password to be %q", test.name, test.expectedPassword)
+		}
+
+		// only a new password logs the user out elsewhere
+		if sessionExists(other) != (test.expectedStatusCode != http.StatusSeeOther) {
+			t.Errorf("%s: expected the other session to be ended to be %t", test.name, test.expectedStatusCode == http.StatusSeeOther)
+		}
+
+		ctx, _ := app.Session.Load(context.Background(), "")
+		_, _ = app.destroyUserSessions(ctx, 2, func(string) bool { return true })
+	}
+
+	resetDB()
+}
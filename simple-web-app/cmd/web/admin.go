@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// inviteTokenExpiry is how long users created by an administrator have to
+// choose their password.
+var inviteTokenExpiry = 7 * 24 * time.Hour
+
+// UserList is a page of the users an administrator searched for.
+type UserList struct {
+	Users    []*data.User
+	Total    int
+	Page     int
+	LastPage int
+	// Prev and Next link to the pages before and after this one, if any.
+	Prev string
+	Next string
+}
+
+// AdminUsers lists the users, filtered by the name and email query
+// parameters, a page at a time.
+func (app *application) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	filter := repository.UserFilter{
+		Name:  strings.TrimSpace(qs.Get("name")),
+		Email: strings.TrimSpace(qs.Get("email")),
+		Page:  1,
+	}
+
+	if v := qs.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			app.errorPage(w, r, &webError{Status: http.StatusBadRequest, Message: "The page number is invalid."})
+			return
+		}
+		filter.Page = page
+	}
+
+	users, total, err := app.DB.ListUsers(r.Context(), filter)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	list := UserList{Users: users, Total: total, Page: filter.Page}
+	list.LastPage = (total + filter.Limit() - 1) / filter.Limit()
+	if list.LastPage < 1 {
+		list.LastPage = 1
+	}
+	if list.Page > 1 {
+		list.Prev = repository.PageURL(r.URL, list.Page-1)
+	}
+	if list.Page < list.LastPage {
+		list.Next = repository.PageURL(r.URL, list.Page+1)
+	}
+
+	_ = app.render(w, r, "admin-users.page.gohtml", &TemplateData{
+		Form: NewForm(qs),
+		Data: map[string]any{"users": list},
+	})
+}
+
+// AdminNewUserPage shows the form to create a user.
+func (app *application) AdminNewUserPage(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "admin-new-user.page.gohtml", &TemplateData{Form: NewForm(nil)})
+}
+
+// AdminCreateUser creates a user from the form. The administrator doesn't
+// choose a password for them; the new user is emailed a link to choose one,
+// which verifies their address as well.
+func (app *application) AdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	checkUserDetails(form)
+	if !form.Valid() {
+		app.renderAccountForm(w, r, "admin-new-user.page.gohtml", form)
+		return
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	user := data.User{
+		FirstName: form.Data.Get("first_name"),
+		LastName:  form.Data.Get("last_name"),
+		Email:     form.Data.Get("email"),
+		Password:  password,
+	}
+	if form.Has("is_admin") {
+		user.IsAdmin = 1
+	}
+
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "An account with this email address already exists")
+		app.renderAccountForm(w, r, "admin-new-user.page.gohtml", form)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.sendTokenEmail(r.Context(), &user, data.ScopePasswordReset, inviteTokenExpiry, "/reset-password",
+		"Your new account",
+		"an account has been created for you. Choose a password for it here:")
+	if err != nil {
+		log.Println("could not send invitation email:", err)
+		app.Session.Put(r.Context(), "error", "The user has been created, but we could not email them; reset their password to try again")
+	} else {
+		app.Session.Put(r.Context(), "flash", "The user has been created and emailed a link to choose a password")
+	}
+
+	http.Redirect(w, r, adminUserPath(user.ID), http.StatusSeeOther)
+}
+
+// AdminUserPage shows a user, with the form to edit them and the actions an
+// administrator can take on them.
+func (app *application) AdminUserPage(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	form := NewForm(url.Values{
+		"first_name": {user.FirstName},
+		"last_name":  {user.LastName},
+		"email":      {user.Email},
+	})
+	_ = app.render(w, r, "admin-user.page.gohtml", &TemplateData{Form: form, Data: map[string]any{"user": user}})
+}
+
+// AdminUpdateUser saves the name and email address of a user. Like when users
+// change it themselves, a new address has to be verified again.
+func (app *application) AdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	checkUserDetails(form)
+	if !form.Valid() {
+		app.renderAdminUserForm(w, r, user, form)
+		return
+	}
+
+	emailChanged := !strings.EqualFold(user.Email, form.Data.Get("email"))
+	updated := *user
+	updated.FirstName = form.Data.Get("first_name")
+	updated.LastName = form.Data.Get("last_name")
+	updated.Email = form.Data.Get("email")
+
+	err = app.DB.UpdateUser(r.Context(), updated)
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "An account with this email address already exists")
+		app.renderAdminUserForm(w, r, user, form)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if updated.ID == app.sessionUserID(r.Context()) {
+		err = app.refreshSessionUser(r.Context())
+		if err != nil {
+			app.errorPage(w, r, err)
+			return
+		}
+	}
+
+	if emailChanged {
+		err = app.sendVerificationEmail(r.Context(), &updated)
+		if err != nil {
+			log.Println("could not send verification email:", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "The user has been saved")
+	http.Redirect(w, r, adminUserPath(user.ID), http.StatusSeeOther)
+}
+
+// renderAdminUserForm shows the page of user again, with the errors of form.
+func (app *application) renderAdminUserForm(w http.ResponseWriter, r *http.Request, user *data.User, form *Form) {
+	_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "admin-user.page.gohtml", &TemplateData{Form: form, Data: map[string]any{"user": user}})
+}
+
+// AdminSetAdmin promotes a user to administrator, or demotes them, as the
+// posted is_admin field says: 1 or 0.
+func (app *application) AdminSetAdmin(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.otherUserFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("is_admin")
+	form.PermittedValues("is_admin", "0", "1")
+	if !form.Valid() {
+		app.errorPage(w, r, &webError{Status: http.StatusBadRequest, Message: "Please choose whether the user is an administrator."})
+		return
+	}
+
+	user.IsAdmin, _ = strconv.Atoi(form.Data.Get("is_admin"))
+	err = app.DB.UpdateUser(r.Context(), *user)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if user.IsAdmin == 1 {
+		app.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s is an administrator now", user.FirstName, user.LastName))
+	} else {
+		app.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s is no longer an administrator", user.FirstName, user.LastName))
+	}
+	http.Redirect(w, r, adminUserPath(user.ID), http.StatusSeeOther)
+}
+
+// AdminForcePasswordReset replaces the password of a user with one nobody
+// knows, logs them out everywhere and emails them a link to choose a new one,
+// e.g. when their account may have been taken over.
+func (app *application) AdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.otherUserFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.ResetPassword(r.Context(), user.ID, password)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.logOutEverywhere(r.Context(), user.ID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.sendTokenEmail(r.Context(), user, data.ScopePasswordReset, passwordResetTokenExpiry, "/reset-password",
+		"Reset your password",
+		"an administrator has reset the password of your account. Choose a new password here:")
+	if err != nil {
+		log.Println("could not send password reset email:", err)
+		app.Session.Put(r.Context(), "error", "The password has been reset, but we could not email the user; please try again")
+	} else {
+		app.Session.Put(r.Context(), "flash", "The user has been logged out and emailed a link to choose a new password")
+	}
+
+	http.Redirect(w, r, adminUserPath(user.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteUser deletes a user, with their pictures, and logs them out.
+func (app *application) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.otherUserFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// the images go with the user, so list them first to remove their files
+	images, err := app.DB.ListUserImages(r.Context(), user.ID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.DeleteUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	for _, img := range images {
+		app.deleteImages(r.Context(), img.StorageKeys())
+	}
+
+	_, err = app.destroyUserSessions(r.Context(), user.ID, func(string) bool { return true })
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s has been deleted", user.FirstName, user.LastName))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// userFromURL returns the user whose id is the userID url parameter.
+func (app *application) userFromURL(r *http.Request) (*data.User, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		return nil, &webError{Status: http.StatusNotFound}
+	}
+	return app.DB.GetUser(r.Context(), id)
+}
+
+// otherUserFromURL returns the user of the userID url parameter, for actions
+// administrators may not take on their own account, so that they can't lock
+// themselves out. If it fails, it has responded already and returns false.
+func (app *application) otherUserFromURL(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	user, err := app.userFromURL(r)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return nil, false
+	}
+
+	if user.ID == app.sessionUserID(r.Context()) {
+		app.Session.Put(r.Context(), "error", "You can't do this to your own account")
+		http.Redirect(w, r, adminUserPath(user.ID), http.StatusSeeOther)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// adminUserPath is the path of the admin page of the user with id.
+func adminUserPath(id int) string {
+	return "/admin/users/" + strconv.Itoa(id)
+}
+
+// randomPassword returns a password nobody knows, for accounts whose user is
+// sent a link to choose their own.
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminRequest returns a request to the admin area by the administrator,
+// user 1, for the user with userID in the url, if it isn't empty.
+func adminRequest(method, target, userID string, postedData url.Values) *http.Request {
+	req, _ := http.NewRequest(method, target, strings.NewReader(postedData.Encode()))
+	if postedData != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	if userID != "" {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("userID", userID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	}
+
+	req = addContextAndSessionToReq(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, IsAdmin: 1})
+	return req
+}
+
+func Test_app_AdminUsers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedUsers      []string
+		unexpectedUsers    []string
+	}{
+		{"all users", "", http.StatusOK, []string{"admin@example.com", "jack@example.com"}, nil},
+		{"by name", "?name=JACK", http.StatusOK, []string{"jack@example.com"}, []string{"admin@example.com"}},
+		{"by email", "?email=admin", http.StatusOK, []string{"admin@example.com"}, []string{"jack@example.com"}},
+		{"past the last page", "?page=2", http.StatusOK, []string{"No users found"}, []string{"jack@example.com"}},
+		{"invalid page", "?page=abc", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, test := range tests {
+		req := adminRequest("GET", "/admin/users"+test.query, "", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminUsers).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		for _, expected := range test.expectedUsers {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("%s: did not find %q in response body", test.name, expected)
+			}
+		}
+		for _, unexpected := range test.unexpectedUsers {
+			if strings.Contains(rr.Body.String(), unexpected) {
+				t.Errorf("%s: found %q in response body", test.name, unexpected)
+			}
+		}
+	}
+}
+
+func Test_app_AdminUsers_pagination(t *testing.T) {
+	resetDB()
+	defer resetDB()
+
+	for i := 0; i < repository.DefaultPerPage; i++ {
+		_, _ = app.DB.InsertUser(context.Background(), data.User{FirstName: "Test", LastName: "Zuser", Email: "test" + strconv.Itoa(i) + "@example.com", Password: "secret"})
+	}
+
+	req := adminRequest("GET", "/admin/users?name=u", "", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.AdminUsers).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "page 1 of 2") {
+		t.Error("expected to be on page 1 of 2")
+	}
+	if !strings.Contains(body, `href="/admin/users?name=u&amp;page=2"`) {
+		t.Error("no link to the next page, keeping the search")
+	}
+}
+
+func Test_app_AdminCreateUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedAdmin      int
+	}{
+		{"user", url.Values{"first_name": {"John"}, "last_name": {"Doe"}, "email": {"john@example.com"}}, http.StatusSeeOther, 0},
+		{"administrator", url.Values{"first_name": {"John"}, "last_name": {"Doe"}, "email": {"john@example.com"}, "is_admin": {"1"}}, http.StatusSeeOther, 1},
+		{"existing email", url.Values{"first_name": {"John"}, "last_name": {"Doe"}, "email": {"jack@example.com"}}, http.StatusUnprocessableEntity, 0},
+		{"invalid email", url.Values{"first_name": {"John"}, "last_name": {"Doe"}, "email": {"john"}}, http.StatusUnprocessableEntity, 0},
+		{"missing name", url.Values{"email": {"john@example.com"}}, http.StatusUnprocessableEntity, 0},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		mail.sent = nil
+
+		req := adminRequest("POST", "/admin/users/new", "", test.postedData)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminCreateUser).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if test.expectedStatusCode != http.StatusSeeOther {
+			if _, sent := mail.last(); sent {
+				t.Errorf("%s: an email was sent", test.name)
+			}
+			continue
+		}
+
+		user, err := app.DB.GetUserByEmail(context.Background(), "john@example.com")
+		if err != nil {
+			t.Errorf("%s: the user was not created: %s", test.name, err)
+			continue
+		}
+
+		if loc := rr.Header().Get("Location"); loc != adminUserPath(user.ID) {
+			t.Errorf("%s: expected location %s, but got %s", test.name, adminUserPath(user.ID), loc)
+		}
+
+		if user.IsAdmin != test.expectedAdmin {
+			t.Errorf("%s: expected is_admin %d, but got %d", test.name, test.expectedAdmin, user.IsAdmin)
+		}
+
+		// the new user chooses their password with the link
+		if userID, err := app.DB.ConsumeToken(context.Background(), data.ScopePasswordReset, data.HashToken(mailedToken(t, "john@example.com"))); err != nil || userID != user.ID {
+			t.Errorf("%s: the link in the email does not work: %v", test.name, err)
+		}
+	}
+
+	resetDB()
+}
+
+func Test_app_AdminUserPage(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             string
+		expectedStatusCode int
+	}{
+		{"user", "2", http.StatusOK},
+		{"no such user", "100", http.StatusNotFound},
+		{"not a number", "jack", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req := adminRequest("GET", "/admin/users/"+test.userID, test.userID, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminUserPage).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if test.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), `value="jack@example.com"`) {
+			t.Errorf("%s: the form is not filled in with the user's details", test.name)
+		}
+	}
+}
+
+func Test_app_AdminUpdateUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedEmail      string
+		expectedVerified   bool
+		expectedMail       bool
+	}{
+		{
+			name: "new name", userID: "2",
+			postedData:         url.Values{"first_name": {"Jacky"}, "last_name": {"Smith"}, "email": {"jack@example.com"}},
+			expectedStatusCode: http.StatusSeeOther, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name: "new email", userID: "2",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jacky@example.com"}},
+			expectedStatusCode: http.StatusSeeOther, expectedEmail: "jacky@example.com", expectedMail: true,
+		},
+		{
+			name: "email of another user", userID: "2",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"admin@example.com"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name: "missing name", userID: "2",
+			postedData:         url.Values{"email": {"jack@example.com"}},
+			expectedStatusCode: http.StatusUnprocessableEntity, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+		{
+			name: "no such user", userID: "100",
+			postedData:         url.Values{"first_name": {"Jack"}, "last_name": {"Smith"}, "email": {"jacky@example.com"}},
+			expectedStatusCode: http.StatusNotFound, expectedEmail: "jack@example.com", expectedVerified: true,
+		},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		mail.sent = nil
+
+		req := adminRequest("POST", "/admin/users/"+test.userID, test.userID, test.postedData)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminUpdateUser).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		if user.Email != test.expectedEmail || user.Verified != test.expectedVerified {
+			t.Errorf("%s: expected email %s, verified %t, but got %s, %t", test.name, test.expectedEmail, test.expectedVerified, user.Email, user.Verified)
+		}
+
+		if user.IsAdmin != 0 {
+			t.Errorf("%s: the user was made an administrator", test.name)
+		}
+
+		if _, sent := mail.last(); sent != test.expectedMail {
+			t.Errorf("%s: expected email to be sent to be %t", test.name, test.expectedMail)
+		}
+	}
+
+	resetDB()
+}
+
+func Test_app_AdminSetAdmin(t *testing.T) {
+	resetDB()
+	defer resetDB()
+
+	var tests = []struct {
+		name               string
+		userID             int
+		isAdmin            string
+		expectedStatusCode int
+		expectedAdmin      int
+	}{
+		{"promote", 2, "1", http.StatusSeeOther, 1},
+		{"demote", 2, "0", http.StatusSeeOther, 0},
+		{"invalid value", 2, "yes", http.StatusBadRequest, 0},
+		{"own account", 1, "0", http.StatusSeeOther, 1},
+		{"no such user", 100, "1", http.StatusNotFound, 0},
+	}
+
+	for _, test := range tests {
+		req := adminRequest("POST", adminUserPath(test.userID)+"/admin", strconv.Itoa(test.userID), url.Values{"is_admin": {test.isAdmin}})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminSetAdmin).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if user, err := app.DB.GetUser(context.Background(), test.userID); err == nil && user.IsAdmin != test.expectedAdmin {
+			t.Errorf("%s: expected is_admin %d, but got %d", test.name, test.expectedAdmin, user.IsAdmin)
+		}
+	}
+}
+
+func Test_app_AdminForcePasswordReset(t *testing.T) {
+	var tests = []struct {
+		name          string
+		userID        int
+		expectedReset bool
+	}{
+		{"user", 2, true},
+		{"own account", 1, false},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		mail.sent = nil
+		other := storeUserSession(t, data.User{ID: test.userID}, "10.0.0.2")
+
+		id := test.userID
+		req := adminRequest("POST", adminUserPath(id)+"/reset-password", strconv.Itoa(id), url.Values{})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminForcePasswordReset).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != adminUserPath(id) {
+			t.Errorf("%s: expected location %s, but got %s", test.name, adminUserPath(id), loc)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), id)
+		if matches, _ := user.PasswordMatches("secret"); matches == test.expectedReset {
+			t.Errorf("%s: expected the password to be reset to be %t", test.name, test.expectedReset)
+		}
+
+		if sessionExists(other) == test.expectedReset {
+			t.Errorf("%s: expected the user to be logged out to be %t", test.name, test.expectedReset)
+		}
+
+		if _, sent := mail.last(); sent != test.expectedReset {
+			t.Errorf("%s: expected email to be sent to be %t", test.name, test.expectedReset)
+		}
+		if test.expectedReset {
+			mailedToken(t, user.Email)
+		}
+
+		ctx, _ := app.Session.Load(context.Background(), "")
+		_, _ = app.destroyUserSessions(ctx, id, func(string) bool { return true })
+	}
+
+	resetDB()
+}
+
+func Test_app_AdminDeleteUser(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	var tests = []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+		expectedLoc        string
+		expectedDeleted    bool
+	}{
+		{"user", 2, http.StatusSeeOther, "/admin/users", true},
+		{"own account", 1, http.StatusSeeOther, "/admin/users/1", false},
+		{"no such user", 100, http.StatusNotFound, "", false},
+	}
+
+	for _, test := range tests {
+		resetDB()
+		cleanUploads()
+		if test.expectedStatusCode != http.StatusNotFound {
+			storeUserImage(t, test.userID, "picture")
+		}
+		other := storeUserSession(t, data.User{ID: test.userID}, "10.0.0.2")
+
+		req := adminRequest("POST", adminUserPath(test.userID)+"/delete", strconv.Itoa(test.userID), url.Values{})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.AdminDeleteUser).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if loc := rr.Header().Get("Location"); loc != test.expectedLoc {
+			t.Errorf("%s: expected location %s, but got %s", test.name, test.expectedLoc, loc)
+		}
+
+		if test.expectedStatusCode == http.StatusNotFound {
+			continue
+		}
+
+		_, err := app.DB.GetUser(context.Background(), test.userID)
+		if deleted := stderrors.Is(err, repository.ErrNotFound); deleted != test.expectedDeleted {
+			t.Errorf("%s: expected the user to be deleted to be %t", test.name, test.expectedDeleted)
+		}
+
+		if stored := storedUploads(); (len(stored) == 0) != test.expectedDeleted {
+			t.Errorf("%s: expected the pictures to be removed to be %t, found %v", test.name, test.expectedDeleted, stored)
+		}
+
+		if sessionExists(other) == test.expectedDeleted {
+			t.Errorf("%s: expected the user to be logged out to be %t", test.name, test.expectedDeleted)
+		}
+
+		ctx, _ := app.Session.Load(context.Background(), "")
+		_, _ = app.destroyUserSessions(ctx, test.userID, func(string) bool { return true })
+	}
+}
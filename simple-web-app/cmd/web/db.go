@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"simple-web-app/pkg/migrations"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -31,3 +33,18 @@ func (app *application) connectToDB() (*sql.DB, error) {
 	log.Println("Connected to Postgres!")
 	return connection, nil
 }
+
+// migrateDB brings the schema up to date with the migrations embedded in the binary.
+func migrateDB(conn *sql.DB) error {
+	migrator, err := migrations.New(conn)
+	if err != nil {
+		return err
+	}
+
+	err = migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Printf("Database schema at version %d", migrator.Latest())
+	return nil
+}
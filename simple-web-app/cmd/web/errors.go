@@ -0,0 +1,136 @@
+package main
+
+import (
+	stderrors "errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"simple-web-app/pkg/repository"
+)
+
+// webError is an error with the status and message to show for it. Err, the
+// cause, is logged but never shown.
+type webError struct {
+	Status int
+	// Title and Message are shown on the error page; they default to the
+	// status text and a generic message.
+	Title   string
+	Message string
+	Err     error
+}
+
+func (e *webError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.Status)
+	}
+
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", message, e.Err)
+	}
+	return message
+}
+
+func (e *webError) Unwrap() error {
+	return e.Err
+}
+
+// badRequest wraps err, from reading a request, as a 400 error.
+func badRequest(err error) error {
+	return &webError{Status: http.StatusBadRequest, Message: "The request could not be read.", Err: err}
+}
+
+// errorPageFor returns the webError to show for err. Errors of the repository
+// get the matching status: 404, 409, 422; anything else not already a
+// webError is a 500.
+func errorPageFor(err error) *webError {
+	var page webError
+
+	var werr *webError
+	if stderrors.As(err, &werr) {
+		page = *werr
+	} else {
+		switch {
+		case stderrors.Is(err, repository.ErrNotFound):
+			page.Status = http.StatusNotFound
+		case stderrors.Is(err, repository.ErrDuplicateEmail), stderrors.Is(err, repository.ErrConflict):
+			page.Status = http.StatusConflict
+		case stderrors.Is(err, repository.ErrValidation):
+			page.Status = http.StatusUnprocessableEntity
+		default:
+			page.Status = http.StatusInternalServerError
+		}
+	}
+
+	if page.Title == "" {
+		page.Title = http.StatusText(page.Status)
+	}
+
+	if page.Message == "" {
+		switch page.Status {
+		case http.StatusNotFound:
+			page.Message = "The page or record you asked for does not exist."
+		case http.StatusConflict:
+			page.Message = "This conflicts with a change made in the meantime."
+		case http.StatusUnprocessableEntity:
+			page.Message = "Some of the data you sent is invalid."
+		default:
+			page.Message = "Something went wrong on our side. Please try again later."
+		}
+	}
+
+	return &page
+}
+
+// errorPage responds to a request that failed with err, with the error page
+// for its status. Every error is logged, with the request it happened on.
+func (app *application) errorPage(w http.ResponseWriter, r *http.Request, err error) {
+	page := errorPageFor(err)
+	app.logError(r, page.Status, err)
+
+	name := "error-4xx.page.gohtml"
+	if page.Status >= 500 {
+		name = "error-5xx.page.gohtml"
+	}
+
+	td := &TemplateData{Data: map[string]any{
+		"status":  page.Status,
+		"title":   page.Title,
+		"message": page.Message,
+	}}
+
+	// the error page itself may be what's broken
+	if sent, err := app.writeTemplate(w, r, page.Status, name, td); err != nil {
+		log.Println("rendering error page:", err)
+		if !sent {
+			http.Error(w, http.StatusText(page.Status), page.Status)
+		}
+	}
+}
+
+// logError logs err with the status it was answered with and the request it
+// happened on.
+func (app *application) logError(r *http.Request, status int, err error) {
+	ip, _ := r.Context().Value(contextUserKey).(string)
+	log.Printf("%d %s %s ip=%s user=%d: %v", status, r.Method, r.URL.RequestURI(), ip, app.sessionUserID(r.Context()), err)
+}
+
+// recoverPanic turns a panic in a handler into the 500 error page, so a bug
+// affects one request rather than taking down the server. It needs the
+// session, so it runs after LoadAndSave.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				w.Header().Set("Connection", "close")
+				app.errorPage(w, r, fmt.Errorf("panic: %v\n%s", rec, debug.Stack()))
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"simple-web-app/pkg/repository"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_errorPageFor(t *testing.T) {
+	var tests = []struct {
+		name            string
+		err             error
+		expectedStatus  int
+		expectedTitle   string
+		expectedMessage string
+	}{
+		{"not found", fmt.Errorf("loading user: %w", repository.ErrNotFound), http.StatusNotFound, "Not Found", "The page or record you asked for does not exist."},
+		{"duplicate email", repository.ErrDuplicateEmail, http.StatusConflict, "Conflict", "This conflicts with a change made in the meantime."},
+		{"validation", &repository.ValidationError{Field: "email", Message: "is required"}, http.StatusUnprocessableEntity, "Unprocessable Entity", "Some of the data you sent is invalid."},
+		{"unexpected", stderrors.New("connection refused"), http.StatusInternalServerError, "Internal Server Error", "Something went wrong on our side. Please try again later."},
+		{"web error", &webError{Status: http.StatusForbidden, Title: "Form expired", Message: "Reload the page."}, http.StatusForbidden, "Form expired", "Reload the page."},
+		{"wrapped web error", fmt.Errorf("upload: %w", badRequest(io.ErrUnexpectedEOF)), http.StatusBadRequest, "Bad Request", "The request could not be read."},
+	}
+
+	for _, test := range tests {
+		page := errorPageFor(test.err)
+		if page.Status != test.expectedStatus || page.Title != test.expectedTitle || page.Message != test.expectedMessage {
+			t.Errorf("%s: expected %d %q %q but got %d %q %q", test.name,
+				test.expectedStatus, test.expectedTitle, test.expectedMessage, page.Status, page.Title, page.Message)
+		}
+	}
+}
+
+func Test_app_errorPage(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedHTML   string
+	}{
+		{"client error", &webError{Status: http.StatusBadRequest, Message: "Please choose an image to upload.", Err: stderrors.New("secret detail")}, http.StatusBadRequest, "Please choose an image to upload."},
+		{"server error", stderrors.New("secret detail"), http.StatusInternalServerError, "The problem has been logged."},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAndSessionToReq(req, app)
+		rr := httptest.NewRecorder()
+
+		app.errorPage(rr, req, test.err)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), test.expectedHTML) {
+			t.Errorf("%s: did not find %q in response body", test.name, test.expectedHTML)
+		}
+
+		if strings.Contains(rr.Body.String(), "secret detail") {
+			t.Errorf("%s: the cause of the error was shown", test.name)
+		}
+	}
+}
+
+// loggedInClient logs in as the admin through ts, and returns a client with
+// the session cookie and the CSRF token of the session.
+func loggedInClient(t *testing.T, ts *httptest.Server) (*http.Client, string) {
+	jar, _ := cookiejar.New(nil)
+	client := ts.Client()
+	client.Jar = jar
+
+	csrfInPage := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+	token := func(path string) string {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		page, _ := io.ReadAll(resp.Body)
+		match := csrfInPage.FindSubmatch(page)
+		if match == nil {
+			t.Fatalf("no csrf token on %s", path)
+		}
+		return string(match[1])
+	}
+
+	postedData := url.Values{"email": {"admin@example.com"}, "password": {"secret"}, "csrf_token": {token("/")}}
+	resp, err := client.PostForm(ts.URL+"/login", postedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// logging in gives the session a new token
+	return client, token("/user/profile")
+}
+
+func Test_application_survivesFailures(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	mux := app.routes().(*chi.Mux)
+	mux.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("something unexpected")
+	})
+
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	client, csrfToken := loggedInClient(t, ts)
+
+	// upload returns a request uploading file as the profile picture; an
+	// empty name uploads nothing
+	upload := func(file string) *http.Request {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		_ = mw.WriteField("csrf_token", csrfToken)
+		if file != "" {
+			w, _ := mw.CreateFormFile("image", file)
+			img, _ := os.ReadFile("./testdata/" + file)
+			_, _ = w.Write(img)
+		}
+		_ = mw.Close()
+
+		req, _ := http.NewRequest("POST", ts.URL+"/user/upload-profile-pic", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	get := func(path string) *http.Request {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		return req
+	}
+
+	var tests = []struct {
+		name           string
+		setup          func()
+		req            *http.Request
+		expectedStatus int
+	}{
+		{"upload without a file", nil, upload(""), http.StatusBadRequest},
+		{"upload for a deleted user", func() { _ = app.DB.DeleteUser(context.Background(), 1) }, upload("img.png"), http.StatusNotFound},
+		{"broken template", func() {
+			app.Templates = fstest.MapFS{"home.page.gohtml": {Data: []byte(`{{index .Data "list" 5}}`)}}
+			app.ReloadTemplates = true
+		}, get("/"), http.StatusInternalServerError},
+		{"panic", nil, get("/panic"), http.StatusInternalServerError},
+		{"unknown page", nil, get("/fish"), http.StatusNotFound},
+	}
+
+	templates := app.Templates
+	for _, test := range tests {
+		if test.setup != nil {
+			test.setup()
+		}
+
+		resp, err := client.Do(test.req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %s", test.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatus, resp.StatusCode)
+		}
+
+		app.Templates = templates
+		app.ReloadTemplates = false
+
+		// the server is still up, and still works
+		resp, err = ts.Client().Get(ts.URL + "/")
+		if err != nil {
+			t.Fatalf("%s: server did not survive: %s", test.name, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the home page after the failure, but got status %d", test.name, resp.StatusCode)
+		}
+	}
+}
@@ -2,34 +2,27 @@ package main
 
 import (
 	"net/url"
-	"strings"
+	"regexp"
+	"simple-web-app/pkg/validator"
 )
 
-type errors map[string][]string
-
-func (e errors) Get(field string) string {
-	errorSlice := e[field]
-
-	if len(errorSlice) == 0 {
-		return ""
-	}
-
-	return errorSlice[0]
-}
-
-func (e errors) Add(field, message string) {
-	e[field] = append(e[field], message)
-}
+// errors holds the validation messages of a form, by field. It is shared with
+// the api, through the validator package.
+type errors = validator.Errors
 
+// Form validates posted form data. Messages is the catalog error messages
+// are rendered from; swap it for a translation to localise them.
 type Form struct {
-	Data   url.Values
-	Errors errors
+	Data     url.Values
+	Errors   errors
+	Messages validator.Messages
 }
 
 func NewForm(data url.Values) *Form {
 	return &Form{
-		Data:   data,
-		Errors: map[string][]string{},
+		Data:     data,
+		Errors:   map[string][]string{},
+		Messages: validator.DefaultMessages,
 	}
 }
 
@@ -43,14 +36,54 @@ func (f *Form) Has(field string) bool {
 
 func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
-		value := f.Data.Get(field)
-		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
-		}
+		f.Field(field, validator.Required())
 	}
 
 }
 
+// Field checks the posted value of field against rules, recording the message
+// of the first rule that rejects it.
+func (f *Form) Field(field string, rules ...validator.Rule) {
+	v := validator.Validator{Errors: f.Errors, Messages: f.Messages}
+	v.Field(field, f.Data.Get(field), rules...)
+}
+
+// Email checks that field holds an email address.
+func (f *Form) Email(field string) {
+	f.Field(field, validator.Email())
+}
+
+// MinLength checks that field is at least n characters long.
+func (f *Form) MinLength(field string, n int) {
+	f.Field(field, validator.MinLength(n))
+}
+
+// MaxLength checks that field is at most n characters long.
+func (f *Form) MaxLength(field string, n int) {
+	f.Field(field, validator.MaxLength(n))
+}
+
+// Matches checks that field has the same value as other, e.g. a password
+// confirmation.
+func (f *Form) Matches(field, other string) {
+	f.Field(field, validator.Equals(other, f.Data.Get(other)))
+}
+
+// Between checks that field is a whole number from min to max, inclusive.
+func (f *Form) Between(field string, min, max int) {
+	f.Field(field, validator.Between(min, max))
+}
+
+// PermittedValues checks that field holds one of values.
+func (f *Form) PermittedValues(field string, values ...string) {
+	f.Field(field, validator.OneOf(values...))
+}
+
+// Pattern checks that field matches re.
+func (f *Form) Pattern(field string, re *regexp.Regexp) {
+	f.Field(field, validator.Pattern(re))
+}
+
 func (f *Form) Check(ok bool, key, message string) {
 	if !ok {
 		f.Errors.Add(key, message)
@@ -3,6 +3,8 @@ package main
 import (
 	"net/http/httptest"
 	"net/url"
+	"regexp"
+	"simple-web-app/pkg/validator"
 	"testing"
 )
 
@@ -68,3 +70,87 @@ func TestForm_ErrorGet(t *testing.T) {
 		t.Error("Should not have an error but got one")
 	}
 }
+
+func TestForm_Field(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("email", "not an email")
+	postedData.Add("name", "Jack")
+
+	form := NewForm(postedData)
+	form.Field("email", validator.Required(), validator.Email())
+	form.Field("name", validator.Required(), validator.MaxLength(10))
+
+	if form.Valid() {
+		t.Error("form shows valid with an invalid email")
+	}
+
+	if form.Errors.Get("email") == "" {
+		t.Error("expected an error for email, but did not get one")
+	}
+
+	if form.Errors.Get("name") != "" {
+		t.Errorf("did not expect an error for name, but got %s", form.Errors.Get("name"))
+	}
+}
+
+func TestForm_Validators(t *testing.T) {
+	postedData := url.Values{
+		"email":                 {"jack@example.com"},
+		"password":              {"secret"},
+		"password_confirmation": {"Secret"},
+		"age":                   {"42"},
+		"locale":                {"de"},
+		"username":              {"jack_smith"},
+	}
+
+	var tests = []struct {
+		name          string
+		check         func(f *Form)
+		field         string
+		expectedValid bool
+	}{
+		{"email", func(f *Form) { f.Email("email") }, "email", true},
+		{"email invalid", func(f *Form) { f.Email("password") }, "password", false},
+		{"min length", func(f *Form) { f.MinLength("password", 6) }, "password", true},
+		{"min length too short", func(f *Form) { f.MinLength("password", 8) }, "password", false},
+		{"max length", func(f *Form) { f.MaxLength("password", 6) }, "password", true},
+		{"max length too long", func(f *Form) { f.MaxLength("password", 5) }, "password", false},
+		{"matches", func(f *Form) { f.Matches("password", "password") }, "password", true},
+		{"matches mismatch", func(f *Form) { f.Matches("password_confirmation", "password") }, "password_confirmation", false},
+		{"between", func(f *Form) { f.Between("age", 18, 120) }, "age", true},
+		{"between out of range", func(f *Form) { f.Between("age", 1, 10) }, "age", false},
+		{"permitted values", func(f *Form) { f.PermittedValues("locale", "en", "de") }, "locale", true},
+		{"permitted values other", func(f *Form) { f.PermittedValues("locale", "en", "fr") }, "locale", false},
+		{"pattern", func(f *Form) { f.Pattern("username", regexp.MustCompile(`^[a-z_]+$`)) }, "username", true},
+		{"pattern mismatch", func(f *Form) { f.Pattern("username", regexp.MustCompile(`^[a-z]+$`)) }, "username", false},
+		{"missing optional field", func(f *Form) { f.Email("missing") }, "missing", true},
+	}
+
+	for _, test := range tests {
+		form := NewForm(postedData)
+		test.check(form)
+
+		if form.Valid() != test.expectedValid {
+			t.Errorf("%s: expected valid to be %t, errors: %v", test.name, test.expectedValid, form.Errors)
+		}
+
+		if !test.expectedValid && form.Errors.Get(test.field) == "" {
+			t.Errorf("%s: expected an error for %s, but did not get one", test.name, test.field)
+		}
+	}
+}
+
+func TestForm_Messages(t *testing.T) {
+	form := NewForm(url.Values{"password": {"abc"}})
+	form.Messages = validator.Messages{"min_length": "Mindestens {min} Zeichen"}
+	form.MinLength("password", 8)
+	form.Required("name")
+
+	if msg := form.Errors.Get("password"); msg != "Mindestens 8 Zeichen" {
+		t.Errorf("expected translated message, got %q", msg)
+	}
+
+	if msg := form.Errors.Get("name"); msg != "This field cannot be blank" {
+		t.Errorf("expected default message for untranslated key, got %q", msg)
+	}
+}
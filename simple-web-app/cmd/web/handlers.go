@@ -1,21 +1,15 @@
 package main
 
 import (
+	stderrors "errors"
 	"fmt"
-	"html/template"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
 	"time"
 )
 
-var pathToTemplates = "./templates/"
-var uploadPath = "./static/img"
-
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	var td = make(map[string]any)
 	if app.Session.Exists(r.Context(), "test") {
@@ -28,53 +22,26 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
-	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
-}
-
-type TemplateData struct {
-	IP    string
-	Data  map[string]any
-	Error string
-	Flash string
-	User  data.User
-}
-
-func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
-	// parse the template from disk
-	parsedTemplate, err := template.ParseFiles(path.Join(pathToTemplates, t), path.Join(pathToTemplates, "base.layout.gohtml"))
+	pictures, err := app.profilePictures(r.Context(), app.sessionUserID(r.Context()))
 	if err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
-		return err
-	}
-
-	td.IP = app.ipFromContext(r.Context())
-	td.Error = app.Session.PopString(r.Context(), "error")
-	td.Flash = app.Session.PopString(r.Context(), "flash")
-
-	if app.Session.Exists(r.Context(), "user") {
-		td.User = app.Session.Get(r.Context(), "user").(data.User)
+		app.errorPage(w, r, err)
+		return
 	}
 
-	// execute the template, passing data, if any
-	err = parsedTemplate.Execute(w, td)
-	if err != nil {
-		log.Fatal(err)
-		return err
-	}
-	return nil
+	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Data: map[string]any{"pictures": pictures}})
 }
 
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
-		http.Error(w, "bad request", http.StatusBadRequest)
+		app.errorPage(w, r, badRequest(err))
 		return
 	}
 
 	// validate data
 	form := NewForm(r.PostForm)
 	form.Required("email", "password")
+	form.Email("email")
 	if !form.Valid() {
 		// redirect to login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid login credentials")
@@ -85,8 +52,11 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
-	user, err := app.DB.GetUserByEmail(email)
-	if err != nil {
+	user, err := app.DB.GetUserByEmail(r.Context(), email)
+	if err != nil && !stderrors.Is(err, repository.ErrNotFound) {
+		app.errorPage(w, r, err)
+		return
+	} else if err != nil {
 		// redirect to login page with error message
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -101,8 +71,8 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// prevent fixation attack
-	_ = app.Session.RenewToken(r.Context())
+	// log the user in, with a fresh session token to prevent fixation attack
+	app.startUserSession(r, user)
 
 	// store success message in session
 	app.Session.Put(r.Context(), "flash", "Successfully logged in")
@@ -111,21 +81,151 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// RegisterPage shows the sign up form. Users who are logged in already go to
+// their profile instead.
+func (app *application) RegisterPage(w http.ResponseWriter, r *http.Request) {
+	if app.Session.Exists(r.Context(), "user") {
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "register.page.gohtml", &TemplateData{Form: NewForm(nil)})
+}
+
+// Register creates an account from the sign up form, logs the new user in and
+// emails them a link to verify their address.
+func (app *application) Register(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	// validate data
+	form := NewForm(r.PostForm)
+	checkUserDetails(form)
+	form.Required("password", "password_confirmation")
+	checkNewPassword(form)
+	if !form.Valid() {
+		app.renderRegisterForm(w, r, form)
+		return
+	}
+
+	id, err := app.DB.InsertUser(r.Context(), data.User{
+		FirstName: form.Data.Get("first_name"),
+		LastName:  form.Data.Get("last_name"),
+		Email:     form.Data.Get("email"),
+		Password:  form.Data.Get("password"),
+	})
+	if stderrors.Is(err, repository.ErrDuplicateEmail) {
+		form.Errors.Add("email", "An account with this email address already exists")
+		app.renderRegisterForm(w, r, form)
+		return
+	} else if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), id)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	// the account works without a verified address, so a mail server that is
+	// down shouldn't stop the sign up; the user can ask for another link
+	err = app.sendVerificationEmail(r.Context(), user)
+	if err != nil {
+		log.Println("could not send verification email:", err)
+	}
+
+	// log the new user in, with a fresh session token to prevent fixation
+	app.startUserSession(r, user)
+
+	app.Session.Put(r.Context(), "flash", "Welcome, your account has been created")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// renderRegisterForm shows the sign up form again, with the errors of form.
+func (app *application) renderRegisterForm(w http.ResponseWriter, r *http.Request, form *Form) {
+	_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "register.page.gohtml", &TemplateData{Form: form})
+}
+
 func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
 	if valid, err := user.PasswordMatches(password); err != nil || !valid {
 		return false
 	}
 
-	app.Session.Put(r.Context(), "user", user)
 	return true
 }
 
+// Logout ends the session, on the server as well as in the browser.
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	err := app.Session.Destroy(r.Context())
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	// a new, anonymous session carries the message
+	app.Session.Put(r.Context(), "flash", "You have been logged out")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Sessions lists the devices the user is logged in on.
+func (app *application) Sessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.userSessions(r.Context(), app.sessionUserID(r.Context()))
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	_ = app.render(w, r, "sessions.page.gohtml", &TemplateData{Data: map[string]any{"sessions": sessions}})
+}
+
+// RevokeSession logs the user out of the session with the posted id, or of
+// all their other sessions if the id is "others".
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, badRequest(err))
+		return
+	}
+
+	id := r.PostForm.Get("id")
+	match := func(sid string) bool { return sid == id }
+	if id == "others" {
+		match = func(string) bool { return true }
+	}
+
+	n, err := app.destroyUserSessions(r.Context(), app.sessionUserID(r.Context()), match)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	switch {
+	case n == 0:
+		app.Session.Put(r.Context(), "error", "That session has ended already")
+	case n == 1:
+		app.Session.Put(r.Context(), "flash", "The session has been logged out")
+	default:
+		app.Session.Put(r.Context(), "flash", fmt.Sprintf("%d sessions have been logged out", n))
+	}
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts a file from an upload request
-	files, err := app.UploadFiles(r, uploadPath)
+	files, err := app.UploadFiles(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Fatal(err)
+		app.errorPage(w, r, err)
+		return
+	}
+
+	if len(files) == 0 {
+		app.errorPage(w, r, &webError{Status: http.StatusBadRequest, Message: "Please choose an image to upload."})
+		return
 	}
 
 	// get the user from the session
@@ -133,76 +233,28 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 
 	// create a var of type data.UserImage
 	var i = data.UserImage{
-		UserID:   user.ID,
-		FileName: files[0].OriginalFileName,
+		UserID:     user.ID,
+		FileName:   files[0].OriginalFileName,
+		StorageKey: files[0].Key,
+		Variants:   files[0].Variants,
 	}
 
-	// insert the image into user_images
-	_, err = app.DB.InsertUserImage(i)
+	// insert the image into user_images, which makes it the active one and
+	// keeps the old one for the user to go back to
+	_, err = app.DB.InsertUserImage(r.Context(), i)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Fatal(err)
+		app.removeUploads(r.Context(), files)
+		app.errorPage(w, r, err)
+		return
 	}
 
 	// refresh the session variable "user"
-	updatedUser, err := app.DB.GetUser(user.ID)
+	err = app.refreshSessionUser(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Fatal(err)
+		app.errorPage(w, r, err)
+		return
 	}
 
-	app.Session.Put(r.Context(), "user", updatedUser)
-
 	// redirect back to profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
-
-type UploadedFile struct {
-	OriginalFileName string
-	FileSize         int64
-}
-
-func (app *application) UploadFiles(r *http.Request, uploadDir string) ([]*UploadedFile, error) {
-	var uploadedFiles []*UploadedFile
-
-	err := r.ParseMultipartForm(int64(1024 * 1024 * 5))
-	if err != nil {
-		return nil, fmt.Errorf("uploaded file is too big, and must be less than 5mb")
-	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-
-				uploadedFile.OriginalFileName = hdr.Filename
-
-				var outFile *os.File
-				defer outFile.Close()
-
-				if outFile, err = os.Create(filepath.Join(uploadDir, uploadedFile.OriginalFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outFile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-				return uploadedFiles, nil
-			}(uploadedFiles)
-
-			if err != nil {
-				return uploadedFiles, err
-			}
-		}
-	}
-
-	return uploadedFiles, nil
-}
@@ -11,10 +11,12 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"simple-web-app/pkg/data"
 	"strings"
 	"sync"
@@ -32,6 +34,9 @@ func Test_application_handlers(t *testing.T) {
 		{"home", "/", http.StatusOK, "/", http.StatusOK},
 		{"404", "/fish", http.StatusNotFound, "/fish", http.StatusNotFound},
 		{"profile", "/user/profile", http.StatusOK, "/", http.StatusTemporaryRedirect},
+		{"register", "/register", http.StatusOK, "/register", http.StatusOK},
+		{"forgot password", "/forgot-password", http.StatusOK, "/forgot-password", http.StatusOK},
+		{"reset password", "/reset-password?token=abc", http.StatusOK, "/reset-password", http.StatusOK},
 	}
 
 	routes := app.routes()
@@ -70,6 +75,53 @@ func Test_application_handlers(t *testing.T) {
 	}
 }
 
+func Test_application_csrf(t *testing.T) {
+	ts := httptest.NewTLSServer(app.routes())
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := ts.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// the login form carries the token of the session
+	resp, err := client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	match := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindSubmatch(page)
+	if match == nil {
+		t.Fatal("no csrf token in login form")
+	}
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+	}{
+		{"without token", "", http.StatusForbidden},
+		{"with token", string(match[1]), http.StatusSeeOther},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"email": {"admin@example.com"}, "password": {"secret"}, "csrf_token": {test.token}}
+		resp, err := client.PostForm(ts.URL+"/login", postedData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatusCode, resp.StatusCode)
+		}
+	}
+}
+
 func TestAppHome(t *testing.T) {
 	var tests = []struct {
 		name         string
@@ -106,21 +158,6 @@ func TestAppHome(t *testing.T) {
 	}
 }
 
-func TestApp_renderWithBadTemplate(t *testing.T) {
-	// set template path to a location with a bad template
-	pathToTemplates = "./testdata/"
-
-	req, _ := http.NewRequest("GET", "/", nil)
-	req = addContextAndSessionToReq(req, app)
-	rr := httptest.NewRecorder()
-
-	err := app.render(rr, req, "bad.page.gohtml", &TemplateData{})
-	if err == nil {
-		t.Error("Expected error from bad template, but did not get one")
-	}
-	pathToTemplates = "./../../templates/"
-}
-
 func getCtx(req *http.Request) context.Context {
 	ctx := context.WithValue(req.Context(), contextUserKey, "unknown")
 	return ctx
@@ -189,6 +226,174 @@ func Test_app_Login(t *testing.T) {
 	}
 }
 
+func Test_app_Register(t *testing.T) {
+	valid := url.Values{
+		"first_name":            {"John"},
+		"last_name":             {"Doe"},
+		"email":                 {"john@example.com"},
+		"password":              {"correct horse"},
+		"password_confirmation": {"correct horse"},
+	}
+
+	// with returns a copy of valid, with field set to value
+	with := func(field, value string) url.Values {
+		v := url.Values{}
+		for key, values := range valid {
+			v[key] = values
+		}
+		v.Set(field, value)
+		return v
+	}
+
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedLoc        string
+		expectedError      string
+	}{
+		{"valid", valid, http.StatusSeeOther, "/user/profile", ""},
+		{"duplicate email", with("email", "ADMIN@example.com"), http.StatusUnprocessableEntity, "", "An account with this email address already exists"},
+		{"missing name", with("first_name", ""), http.StatusUnprocessableEntity, "", "This field cannot be blank"},
+		{"invalid email", with("email", "john"), http.StatusUnprocessableEntity, "", "Invalid email address"},
+		{"short password", with("password", "short"), http.StatusUnprocessableEntity, "", "This field must be at least 8 characters long"},
+		{"passwords differ", with("password_confirmation", "correct horses"), http.StatusUnprocessableEntity, "", "This field must match password"},
+	}
+
+	for _, test := range tests {
+		resetDB()
+
+		req, _ := http.NewRequest("POST", "/register", strings.NewReader(test.postedData.Encode()))
+		req = addContextAndSessionToReq(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.Register)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: returned wrong status code; expected %d, but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+
+		if test.expectedLoc != "" {
+			actualLoc, err := rr.Result().Location()
+			if err != nil || actualLoc.String() != test.expectedLoc {
+				t.Errorf("%s: expected location %s, but got %v", test.name, test.expectedLoc, actualLoc)
+			}
+
+			user, ok := app.Session.Get(req.Context(), "user").(data.User)
+			if !ok || user.Email != "john@example.com" {
+				t.Errorf("%s: new user was not logged in", test.name)
+			}
+
+			if matches, _ := user.PasswordMatches("correct horse"); !matches {
+				t.Errorf("%s: password was not stored", test.name)
+			}
+
+			if msg, ok := mail.last(); !ok || msg.To != "john@example.com" || !strings.Contains(msg.Body, "/verify-email?token=") {
+				t.Errorf("%s: no verification email was sent", test.name)
+			}
+		}
+
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("%s: did not find %q in response body", test.name, test.expectedError)
+		}
+	}
+
+	resetDB()
+}
+
+func Test_app_Logout(t *testing.T) {
+	token := storeUserSession(t, data.User{ID: 1}, "10.0.0.1")
+
+	req, _ := http.NewRequest("POST", "/logout", nil)
+	req.Header.Set("X-Session", token)
+	req = addContextAndSessionToReq(req, app)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Logout)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	if sessionExists(token) {
+		t.Error("session was not destroyed")
+	}
+
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("user is still logged in")
+	}
+}
+
+func Test_app_Sessions(t *testing.T) {
+	token := storeUserSession(t, data.User{ID: 30}, "10.0.0.1")
+	storeUserSession(t, data.User{ID: 30}, "10.0.0.2")
+
+	req, _ := http.NewRequest("GET", "/user/sessions", nil)
+	req.Header.Set("X-Session", token)
+	req = addContextAndSessionToReq(req, app)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(app.Sessions)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	for _, expected := range []string{"10.0.0.1", "10.0.0.2", "This device"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("did not find %q in response body", expected)
+		}
+	}
+}
+
+func Test_app_RevokeSession(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            func(other string) string
+		expectedEnded bool
+		expectedFlash string
+	}{
+		{"one session", sessionID, true, "The session has been logged out"},
+		{"all others", func(string) string { return "others" }, true, "The session has been logged out"},
+		{"unknown session", func(string) string { return "nope" }, false, ""},
+	}
+
+	for _, test := range tests {
+		current := storeUserSession(t, data.User{ID: 40}, "10.0.0.1")
+		other := storeUserSession(t, data.User{ID: 40}, "10.0.0.2")
+
+		postedData := url.Values{"id": {test.id(other)}}
+		req, _ := http.NewRequest("POST", "/user/sessions/revoke", strings.NewReader(postedData.Encode()))
+		req.Header.Set("X-Session", current)
+		req = addContextAndSessionToReq(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(app.RevokeSession)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/user/sessions" {
+			t.Errorf("%s: expected location /user/sessions, but got %s", test.name, loc)
+		}
+
+		if sessionExists(other) == test.expectedEnded {
+			t.Errorf("%s: expected other session to be ended to be %t", test.name, test.expectedEnded)
+		}
+
+		if !sessionExists(current) {
+			t.Errorf("%s: current session was ended", test.name)
+		}
+
+		if flash := app.Session.GetString(req.Context(), "flash"); flash != test.expectedFlash {
+			t.Errorf("%s: expected flash %q, but got %q", test.name, test.expectedFlash, flash)
+		}
+
+		// leave no sessions of user 40 behind for the next case
+		ctx, _ := app.Session.Load(context.Background(), "")
+		_, _ = app.destroyUserSessions(ctx, 40, func(string) bool { return true })
+	}
+}
+
 func Test_app_UploadFiles(t *testing.T) {
 	// set up pipes
 	pr, pw := io.Pipe()
@@ -208,18 +413,22 @@ func Test_app_UploadFiles(t *testing.T) {
 	request.Header.Add("Content-Type", writer.FormDataContentType())
 
 	// call app.UploadFiles
-	uploadedFiles, err := app.UploadFiles(request, "./testdata/uploads")
+	uploadedFiles, err := app.UploadFiles(request)
 	if err != nil {
 		t.Error(err)
 	}
 
 	// perform our tests
-	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName)); os.IsNotExist(err) {
+	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].Key)); os.IsNotExist(err) {
 		t.Errorf("expected file to exist: %s", err.Error())
 	}
 
+	if uploadedFiles[0].OriginalFileName != "img.png" || uploadedFiles[0].ContentType != "image/png" {
+		t.Errorf("unexpected upload %+v", uploadedFiles[0])
+	}
+
 	// clean up
-	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName))
+	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].Key))
 	wg.Wait()
 }
 
@@ -264,50 +473,60 @@ func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing
 }
 
 func Test_app_UploadProfilePic(t *testing.T) {
-	uploadPath = "./testdata/uploads"
 	filePath := "./testdata/img.png"
 
 	// specify a field name for the form
 	fieldName := "file"
 
-	// create a bytes.Buffer to act as request body
+	var tests = []struct {
+		name               string
+		userID             int
+		expectedStatusCode int
+	}{
+		{"valid user", 1, http.StatusSeeOther},
+		{"user no longer exists", 100, http.StatusNotFound},
+	}
 
-	body := new(bytes.Buffer)
+	for _, test := range tests {
+		// create a bytes.Buffer to act as request body
+		body := new(bytes.Buffer)
 
-	// create a new writer
-	mw := multipart.NewWriter(body)
+		// create a new writer
+		mw := multipart.NewWriter(body)
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		t.Fatal(err)
-	}
+		file, err := os.Open(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	w, err := mw.CreateFormFile(fieldName, filePath)
-	if err != nil {
-		t.Fatal(err)
-	}
+		w, err := mw.CreateFormFile(fieldName, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-	if _, err := io.Copy(w, file); err != nil {
-		t.Fatal(err)
-	}
+		if _, err := io.Copy(w, file); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
 
-	mw.Close()
+		mw.Close()
 
-	req := httptest.NewRequest(http.MethodPost, "/upload", body)
-	req = addContextAndSessionToReq(req, app)
+		req := httptest.NewRequest(http.MethodPost, "/upload", body)
+		req = addContextAndSessionToReq(req, app)
 
-	app.Session.Put(req.Context(), "user", data.User{ID: 1})
-	req.Header.Add("Content-Type", mw.FormDataContentType())
+		app.Session.Put(req.Context(), "user", data.User{ID: test.userID})
+		req.Header.Add("Content-Type", mw.FormDataContentType())
 
-	rr := httptest.NewRecorder()
+		rr := httptest.NewRecorder()
 
-	handler := http.HandlerFunc(app.UploadProfilePic)
-	handler.ServeHTTP(rr, req)
+		handler := http.HandlerFunc(app.UploadProfilePic)
+		handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusSeeOther {
-		t.Errorf("wrong status code")
-	}
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: wrong status code; expected %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
 
-	// clean up
-	_ = os.Remove("./testdata/uploads/img.png")
+		// clean up
+		cleanUploads()
+	}
 }
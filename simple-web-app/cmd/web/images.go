@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"simple-web-app/pkg/data"
+	"strconv"
+)
+
+// ProfilePicture is a picture a user has uploaded, with the URLs to show it
+// by, as in TemplateData.ProfilePicURLs.
+type ProfilePicture struct {
+	data.UserImage
+	URLs map[string]string
+}
+
+// profilePictures lists the pictures userID has uploaded, newest first.
+func (app *application) profilePictures(ctx context.Context, userID int) ([]ProfilePicture, error) {
+	images, err := app.DB.ListUserImages(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pictures := make([]ProfilePicture, 0, len(images))
+	for _, img := range images {
+		urls, err := app.imageURLs(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		pictures = append(pictures, ProfilePicture{UserImage: img, URLs: urls})
+	}
+
+	return pictures, nil
+}
+
+// refreshSessionUser loads the user logged in with the session in ctx again,
+// so that the session shows changes such as a new profile picture.
+func (app *application) refreshSessionUser(ctx context.Context) error {
+	user, err := app.DB.GetUser(ctx, app.sessionUserID(ctx))
+	if err != nil {
+		return err
+	}
+
+	app.Session.Put(ctx, "user", *user)
+	return nil
+}
+
+// postedImageID returns the image id posted in the form field "id".
+func postedImageID(r *http.Request) (int, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return 0, badRequest(err)
+	}
+
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil || id <= 0 {
+		return 0, &webError{Status: http.StatusBadRequest, Message: "Please choose a picture."}
+	}
+	return id, nil
+}
+
+// ActivateProfilePic makes one of the pictures the user uploaded before their
+// profile picture again.
+func (app *application) ActivateProfilePic(w http.ResponseWriter, r *http.Request) {
+	id, err := postedImageID(r)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.DB.ActivateUserImage(r.Context(), app.sessionUserID(r.Context()), id)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	err = app.refreshSessionUser(r.Context())
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your profile picture has been changed")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// DeleteProfilePic deletes one of the pictures the user uploaded, with its
+// files. Deleting the current picture leaves the user without one.
+func (app *application) DeleteProfilePic(w http.ResponseWriter, r *http.Request) {
+	id, err := postedImageID(r)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	deleted, err := app.DB.DeleteUserImage(r.Context(), app.sessionUserID(r.Context()), id)
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.deleteImages(r.Context(), deleted.StorageKeys())
+
+	err = app.refreshSessionUser(r.Context())
+	if err != nil {
+		app.errorPage(w, r, err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "The picture has been deleted")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/storage"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// storeUserImage saves an image with a thumbnail for userID, as an upload
+// would, and returns it.
+func storeUserImage(t *testing.T, userID int, key string) data.UserImage {
+	ctx := context.Background()
+
+	img := data.UserImage{
+		UserID:     userID,
+		FileName:   key,
+		StorageKey: key + ".png",
+		Variants:   []data.ImageVariant{{Name: "thumbnail", StorageKey: key + "-thumbnail.png", Width: 64, Height: 64}},
+	}
+	for _, k := range img.StorageKeys() {
+		if err := app.Storage.Put(ctx, k, strings.NewReader("image"), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	id, err := app.DB.InsertUserImage(ctx, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.ID = id
+	return img
+}
+
+// postImageID posts the image id to handler as user 2 and returns the
+// response and the request, whose session has the flash messages.
+func postImageID(handler http.HandlerFunc, id string) (*httptest.ResponseRecorder, *http.Request) {
+	postedData := url.Values{"id": {id}}
+	req, _ := http.NewRequest("POST", "/user/profile-pic", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToReq(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app.Session.Put(req.Context(), "user", data.User{ID: 2})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, req
+}
+
+func Test_app_Profile_pictures(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	storeUserImage(t, 2, "first")
+	storeUserImage(t, 2, "second")
+
+	req, _ := http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToReq(req, app)
+	user, _ := app.DB.GetUser(context.Background(), 2)
+	app.Session.Put(req.Context(), "user", *user)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.Profile).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, expected := range []string{"/static/img/first-thumbnail.png", "/static/img/second-thumbnail.png", "Current picture", "/user/profile-pic/activate"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("did not find %q in response body", expected)
+		}
+	}
+
+	// only the picture that is not the current one can be chosen
+	if n := strings.Count(body, `action="/user/profile-pic/activate"`); n != 1 {
+		t.Errorf("expected 1 picture to choose, but found %d", n)
+	}
+}
+
+func Test_app_ActivateProfilePic(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	first := storeUserImage(t, 2, "first")
+	storeUserImage(t, 2, "second")
+	others := storeUserImage(t, 1, "others")
+
+	var tests = []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedActive int
+	}{
+		{"previous picture", strconv.Itoa(first.ID), http.StatusSeeOther, first.ID},
+		{"no id", "", http.StatusBadRequest, first.ID},
+		{"not a number", "abc", http.StatusBadRequest, first.ID},
+		{"no such picture", "1000", http.StatusNotFound, first.ID},
+		{"picture of another user", strconv.Itoa(others.ID), http.StatusNotFound, first.ID},
+	}
+
+	for _, test := range tests {
+		rr, req := postImageID(app.ActivateProfilePic, test.id)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		if user.ProfilePic.ID != test.expectedActive {
+			t.Errorf("%s: expected picture %d to be active, but got %d", test.name, test.expectedActive, user.ProfilePic.ID)
+		}
+
+		if test.expectedStatus != http.StatusSeeOther {
+			continue
+		}
+
+		if sessionUser, ok := app.Session.Get(req.Context(), "user").(data.User); !ok || sessionUser.ProfilePic.ID != first.ID {
+			t.Errorf("%s: the session user was not refreshed", test.name)
+		}
+	}
+
+	// the other user's picture is left as it was
+	other, _ := app.DB.GetUser(context.Background(), 1)
+	if other.ProfilePic.ID != others.ID {
+		t.Errorf("expected the picture of user 1 to be %d, but got %d", others.ID, other.ProfilePic.ID)
+	}
+}
+
+func Test_app_DeleteProfilePic(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	first := storeUserImage(t, 2, "first")
+	second := storeUserImage(t, 2, "second")
+	others := storeUserImage(t, 1, "others")
+
+	var tests = []struct {
+		name           string
+		id             string
+		expectedStatus int
+		deleted        *data.UserImage
+		expectedActive int
+	}{
+		{"previous picture", strconv.Itoa(first.ID), http.StatusSeeOther, &first, second.ID},
+		{"deleted already", strconv.Itoa(first.ID), http.StatusNotFound, nil, second.ID},
+		{"not a number", "abc", http.StatusBadRequest, nil, second.ID},
+		{"picture of another user", strconv.Itoa(others.ID), http.StatusNotFound, nil, second.ID},
+		{"current picture", strconv.Itoa(second.ID), http.StatusSeeOther, &second, 0},
+	}
+
+	for _, test := range tests {
+		rr, _ := postImageID(app.DeleteProfilePic, test.id)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		if user.ProfilePic.ID != test.expectedActive {
+			t.Errorf("%s: expected picture %d to be active, but got %d", test.name, test.expectedActive, user.ProfilePic.ID)
+		}
+
+		if test.deleted == nil {
+			continue
+		}
+		for _, key := range test.deleted.StorageKeys() {
+			if _, err := app.Storage.Open(context.Background(), key); !stderrors.Is(err, storage.ErrNotFound) {
+				t.Errorf("%s: %s was not removed", test.name, key)
+			}
+		}
+	}
+
+	// the other user's picture and its files are left alone
+	if stored := storedUploads(); len(stored) != 2 {
+		t.Errorf("expected only the files of user 1 to be left, found %v", stored)
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/gob"
 	"flag"
+	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/imaging"
+	"simple-web-app/pkg/mailer"
 	"simple-web-app/pkg/repository"
 	"simple-web-app/pkg/repository/dbrepo"
+	"simple-web-app/pkg/storage"
+	"simple-web-app/templates"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -16,6 +24,19 @@ type application struct {
 	DSN     string
 	DB      repository.DatabaseRepo
 	Session *scs.SessionManager
+	Mailer  mailer.Mailer
+	// BaseURL is where the app is reachable, used for links in emails.
+	BaseURL string
+	// Templates holds the page templates. They are parsed into TemplateCache
+	// at startup, or on every render if ReloadTemplates is set, so changes
+	// show without a restart.
+	Templates       fs.FS
+	TemplateCache   map[string]*template.Template
+	ReloadTemplates bool
+	// Uploads limits the images users can upload.
+	Uploads UploadConfig
+	// Storage keeps the images users upload.
+	Storage storage.Store
 }
 
 func main() {
@@ -23,17 +44,130 @@ func main() {
 	// set up an app config
 	app := application{}
 	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres connection")
+	migrate := flag.Bool("migrate", false, "apply pending database migrations at startup")
+	dbType := flag.String("db", "postgres", "database to use: postgres, or memory to run without Postgres")
+	fixtures := flag.String("fixtures", "", "JSON file to seed the in-memory database with, e.g. ./fixtures/users.json")
+	flag.StringVar(&app.BaseURL, "base-url", "http://localhost:8080", "URL the app is reachable at, for links in emails")
+	mailFrom := flag.String("mail-from", "no-reply@example.com", "sender address of emails")
+	smtpHost := flag.String("smtp-host", "", "SMTP server to send emails through; emails are logged if empty")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP user name")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailDir := flag.String("mail-dir", "", "directory to write emails to as .eml files, instead of logging them")
+	flag.BoolVar(&app.ReloadTemplates, "dev", false, "read templates from ./templates on every request, instead of the ones built in")
+	app.Uploads = defaultUploadConfig
+	flag.Int64Var(&app.Uploads.MaxFileSize, "upload-max-size", defaultUploadConfig.MaxFileSize, "largest image users can upload, in bytes")
+	uploadTypes := flag.String("upload-types", "png,jpeg,gif", "image formats users can upload, out of png, jpeg and gif")
+	imageVariants := flag.String("image-variants", "thumbnail=64x64,medium=400x400", "sizes uploaded images are scaled down to, as name=WIDTHxHEIGHT; the pages use thumbnail and medium")
+	sessionStore := flag.String("session-store", "", "where to keep sessions: postgres, or memory to lose them on restart; defaults to the -db setting")
+	storageType := flag.String("storage", "local", "where to keep uploaded images: local, or s3 to share them between instances")
+	storageDir := flag.String("storage-dir", "./static/img", "directory of the local image storage")
+	storageURL := flag.String("storage-url", "/static/img", "URL the directory of the local image storage is served at")
+	s3 := &storage.S3{}
+	flag.StringVar(&s3.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "URL of the S3 compatible service, e.g. http://localhost:9000 for MinIO")
+	flag.StringVar(&s3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&s3.Bucket, "s3-bucket", "", "S3 bucket to keep images in")
+	flag.StringVar(&s3.AccessKey, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key; defaults to $AWS_ACCESS_KEY_ID")
+	flag.StringVar(&s3.SecretKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret key; defaults to $AWS_SECRET_ACCESS_KEY")
+	flag.BoolVar(&s3.PathStyle, "s3-path-style", false, "address the bucket in the path instead of the host name, as MinIO needs")
+	flag.DurationVar(&s3.URLExpiry, "s3-url-expiry", storage.DefaultURLExpiry, "how long the signed URLs of images are valid for")
 	flag.Parse()
 
-	conn, err := app.connectToDB()
+	var err error
+	app.Uploads.AllowedTypes, err = parseUploadTypes(*uploadTypes)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer conn.Close()
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+
+	app.Uploads.Variants, err = imaging.ParseVariants(*imageVariants)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.Templates = templates.FS
+	if app.ReloadTemplates {
+		app.Templates = os.DirFS("./templates")
+	}
+
+	app.TemplateCache, err = newTemplateCache(app.Templates)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *storageType {
+	case "local":
+		app.Storage = &storage.Filesystem{Dir: *storageDir, BaseURL: *storageURL}
+	case "s3":
+		if s3.Bucket == "" {
+			log.Fatal("the s3 storage needs -s3-bucket")
+		}
+		app.Storage = s3
+		log.Println("Keeping images in S3 bucket", s3.Bucket)
+	default:
+		log.Fatalf("unknown storage %q", *storageType)
+	}
+
+	switch {
+	case *smtpHost != "":
+		app.Mailer = &mailer.SMTPMailer{Host: *smtpHost, Port: *smtpPort, Username: *smtpUsername, Password: *smtpPassword, From: *mailFrom}
+	case *mailDir != "":
+		app.Mailer = &mailer.FileMailer{From: *mailFrom, Dir: *mailDir}
+	default:
+		app.Mailer = &mailer.LogMailer{From: *mailFrom}
+	}
+
+	var conn *sql.DB
+
+	switch *dbType {
+	case "memory":
+		repo := dbrepo.NewMemoryDBRepo()
+		if *fixtures != "" {
+			if err := repo.LoadFixtures(*fixtures); err != nil {
+				log.Fatal(err)
+			}
+		}
+		app.DB = repo
+		log.Println("Using in-memory database")
+	case "postgres":
+		conn, err = app.connectToDB()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+
+		if *migrate {
+			if err := migrateDB(conn); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	default:
+		log.Fatalf("unknown database %q", *dbType)
+	}
 
 	// get a session manager
-	app.Session = getSession()
+	if *sessionStore == "" {
+		*sessionStore = *dbType
+	}
+
+	switch *sessionStore {
+	case "memory":
+		store := dbrepo.NewMemorySessionStore()
+		store.UserID = sessionDataUserID
+		app.Session = getSession(store)
+	case "postgres":
+		if conn == nil {
+			log.Fatal("the postgres session store needs -db=postgres")
+		}
+		store := dbrepo.NewPostgresSessionStore(conn, sessionCleanupInterval)
+		store.UserID = sessionDataUserID
+		defer store.StopCleanup()
+		app.Session = getSession(store)
+		log.Println("Keeping sessions in Postgres")
+	default:
+		log.Fatalf("unknown session store %q", *sessionStore)
+	}
 
 	// get application routes
 	mux := app.routes()
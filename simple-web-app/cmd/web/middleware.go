@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	stderrors "errors"
 	"fmt"
 	"net"
 	"net/http"
+	"simple-web-app/pkg/repository"
 )
 
 type contextKey string
@@ -67,3 +72,105 @@ func (app *application) auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireAdmin only lets administrators through. It must run after auth. The
+// user is looked up again, since the session keeps the rights they had when
+// they logged in, and they may have been demoted since.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.DB.GetUser(r.Context(), app.sessionUserID(r.Context()))
+		if err != nil && !stderrors.Is(err, repository.ErrNotFound) {
+			app.errorPage(w, r, err)
+			return
+		}
+
+		if user == nil || user.IsAdmin != 1 {
+			app.errorPage(w, r, &webError{Status: http.StatusForbidden, Message: "Only administrators may open this page."})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackSession notes the device and time a logged in session is used from, for
+// the sessions page.
+func (app *application) trackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Session.Exists(r.Context(), "user") {
+			app.noteSessionUse(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfSessionKey is the session key of the CSRF token, which forms send back
+// in the csrfField field, and scripts in the csrfHeader header.
+const (
+	csrfSessionKey = "csrf_token"
+	csrfField      = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token of the session in ctx, creating one if it
+// has none yet.
+func (app *application) csrfToken(ctx context.Context) string {
+	if token := app.Session.GetString(ctx, csrfSessionKey); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	app.Session.Put(ctx, csrfSessionKey, token)
+	return token
+}
+
+// csrf rejects requests that change state unless they carry the CSRF token of
+// their session, so other sites can't submit forms on behalf of a user.
+func (app *application) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected := app.Session.GetString(r.Context(), csrfSessionKey)
+
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			if err := parseForm(r); err != nil {
+				app.errorPage(w, r, err)
+				return
+			}
+			sent = r.PostForm.Get(csrfField)
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			app.errorPage(w, r, &webError{
+				Status:  http.StatusForbidden,
+				Title:   "Form expired",
+				Message: "This form has expired or was not sent from this site. Please go back, reload the page and try again.",
+				Err:     stderrors.New("missing or invalid csrf token"),
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitRequestSize caps the size of request bodies at what the largest
+// allowed upload needs, before anything reads them.
+func (app *application) limitRequestSize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: app.Uploads.MaxRequestSize()}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
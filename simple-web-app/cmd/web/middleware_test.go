@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"simple-web-app/pkg/data"
 	"strings"
 	"testing"
@@ -103,3 +107,123 @@ func Test_app_auth(t *testing.T) {
 		}
 	}
 }
+
+func Test_app_requireAdmin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name           string
+		user           data.User
+		expectedStatus int
+	}{
+		{"administrator", data.User{ID: 1, IsAdmin: 1}, http.StatusOK},
+		{"user", data.User{ID: 2}, http.StatusForbidden},
+		{"demoted since logging in", data.User{ID: 2, IsAdmin: 1}, http.StatusForbidden},
+		{"deleted since logging in", data.User{ID: 100, IsAdmin: 1}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		handlerToTest := app.requireAdmin(nextHandler)
+		req := httptest.NewRequest("GET", "/admin/users", nil)
+		req = addContextAndSessionToReq(req, app)
+		app.Session.Put(req.Context(), "user", test.user)
+		rr := httptest.NewRecorder()
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_trackSession(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var tests = []struct {
+		name       string
+		isAuth     bool
+		expectedIP string
+	}{
+		{"logged in", true, "192.3.2.1"},
+		{"not logged in", false, ""},
+	}
+
+	for _, test := range tests {
+		handlerToTest := app.trackSession(nextHandler)
+		req := httptest.NewRequest("GET", "http://testing", nil)
+		req = addContextAndSessionToReq(req, app)
+		req = req.WithContext(context.WithValue(req.Context(), contextUserKey, "192.3.2.1"))
+		req.Header.Set("User-Agent", "test browser")
+		if test.isAuth {
+			app.Session.Put(req.Context(), "user", data.User{ID: 1})
+		}
+		handlerToTest.ServeHTTP(httptest.NewRecorder(), req)
+
+		if ip := app.Session.GetString(req.Context(), sessionIPKey); ip != test.expectedIP {
+			t.Errorf("%s: expected ip %q in session but got %q", test.name, test.expectedIP, ip)
+		}
+	}
+}
+
+func Test_app_csrf(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// the body of a request, given the CSRF token of its session
+	form := func(token string) (string, *bytes.Buffer) {
+		return "application/x-www-form-urlencoded", bytes.NewBufferString(url.Values{"csrf_token": {token}}.Encode())
+	}
+	multipartForm := func(token string) (string, *bytes.Buffer) {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		_ = mw.WriteField("csrf_token", token)
+		_ = mw.Close()
+		return mw.FormDataContentType(), body
+	}
+	noBody := func(string) (string, *bytes.Buffer) { return "", &bytes.Buffer{} }
+	wrongToken := func(string) (string, *bytes.Buffer) { return form("wrong") }
+
+	var tests = []struct {
+		name               string
+		method             string
+		body               func(token string) (string, *bytes.Buffer)
+		header             bool
+		expectedStatusCode int
+	}{
+		{"get", "GET", noBody, false, http.StatusOK},
+		{"post without token", "POST", noBody, false, http.StatusForbidden},
+		{"post with wrong token", "POST", wrongToken, false, http.StatusForbidden},
+		{"post with token", "POST", form, false, http.StatusOK},
+		{"multipart post with token", "POST", multipartForm, false, http.StatusOK},
+		{"delete with header", "DELETE", noBody, true, http.StatusOK},
+		{"put without token", "PUT", noBody, false, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/", nil)
+		req = addContextAndSessionToReq(req, app)
+		token := issueCSRFToken(req)
+
+		contentType, body := test.body(token)
+		req.Body = io.NopCloser(body)
+		req.Header.Set("Content-Type", contentType)
+		if test.header {
+			req = withCSRFToken(req)
+		}
+
+		rr := httptest.NewRecorder()
+		app.csrf(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// a session without a token accepts no token at all
+	req := httptest.NewRequest("POST", "/", nil)
+	req = addContextAndSessionToReq(req, app)
+	rr := httptest.NewRecorder()
+	app.csrf(nextHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("session without token: expected status 403 but got %d", rr.Code)
+	}
+}
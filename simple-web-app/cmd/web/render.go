@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"simple-web-app/pkg/data"
+	"time"
+)
+
+type TemplateData struct {
+	IP    string
+	Data  map[string]any
+	Error string
+	Flash string
+	User  data.User
+	Form  *Form
+	// CSRFToken has to be posted back with every form, as csrf_token.
+	CSRFToken string
+	// ProfilePicURLs are where the profile picture of User, if they have
+	// one, can be loaded from: by variant, e.g. "thumbnail", and "original"
+	// for its full size.
+	ProfilePicURLs map[string]string
+}
+
+// functions can be called from every template.
+var functions = template.FuncMap{
+	"humanDate": humanDate,
+}
+
+// humanDate formats t for people to read, in UTC.
+func humanDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2 Jan 2006 15:04 MST")
+}
+
+// newTemplateCache parses every page in fsys, together with the layouts and
+// partials it may use, and returns them by file name.
+func newTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
+	cache := map[string]*template.Template{}
+
+	pages, err := fs.Glob(fsys, "*.page.gohtml")
+	if err != nil {
+		return nil, err
+	}
+
+	shared := []string{"*.layout.gohtml", "partials/*.gohtml"}
+
+	for _, page := range pages {
+		ts, err := template.New(path.Base(page)).Funcs(functions).ParseFS(fsys, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pattern := range shared {
+			// ParseFS fails on patterns without matches, e.g. when there are no partials
+			if matches, _ := fs.Glob(fsys, pattern); len(matches) == 0 {
+				continue
+			}
+
+			ts, err = ts.ParseFS(fsys, pattern)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cache[path.Base(page)] = ts
+	}
+
+	return cache, nil
+}
+
+// template returns the parsed page t, from the cache, or from app.Templates
+// when they are reloaded on every render.
+func (app *application) template(t string) (*template.Template, error) {
+	cache := app.TemplateCache
+	if app.ReloadTemplates {
+		var err error
+		cache, err = newTemplateCache(app.Templates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ts, ok := cache[t]
+	if !ok {
+		return nil, fmt.Errorf("template %s does not exist", t)
+	}
+	return ts, nil
+}
+
+func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
+	return app.renderStatus(w, r, http.StatusOK, t, td)
+}
+
+// renderStatus renders the page t with the given status, or the error page if
+// that fails. The page is rendered into a buffer first, so that a broken
+// template doesn't result in half a page.
+func (app *application) renderStatus(w http.ResponseWriter, r *http.Request, status int, t string, td *TemplateData) error {
+	sent, err := app.writeTemplate(w, r, status, t, td)
+	if err != nil {
+		err = fmt.Errorf("rendering %s: %w", t, err)
+		if sent {
+			// too late for the error page, the client has the status already
+			app.logError(r, status, err)
+		} else {
+			app.errorPage(w, r, err)
+		}
+	}
+	return err
+}
+
+// writeTemplate renders the page t into a buffer, and only writes the status
+// and page to w if that succeeds. It reports whether it got as far as sending
+// the status, after which nothing else can be sent instead. The flash and
+// error messages are only taken from the session once the page is rendered,
+// so that they are still there for the error page if it isn't.
+func (app *application) writeTemplate(w http.ResponseWriter, r *http.Request, status int, t string, td *TemplateData) (bool, error) {
+	ts, err := app.template(t)
+	if err != nil {
+		return false, err
+	}
+
+	td.IP = app.ipFromContext(r.Context())
+	td.Error = app.Session.GetString(r.Context(), "error")
+	td.Flash = app.Session.GetString(r.Context(), "flash")
+	td.CSRFToken = app.csrfToken(r.Context())
+
+	if app.Session.Exists(r.Context(), "user") {
+		td.User = app.Session.Get(r.Context(), "user").(data.User)
+	}
+
+	// URLs of the image storage may expire, so they are resolved for each page
+	if td.User.ProfilePic.StorageKey != "" {
+		td.ProfilePicURLs, err = app.imageURLs(r.Context(), td.User.ProfilePic)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// execute the template, passing data, if any
+	buf := new(bytes.Buffer)
+	err = ts.Execute(buf, td)
+	if err != nil {
+		return false, err
+	}
+
+	// the messages have been shown now
+	app.Session.Remove(r.Context(), "error")
+	app.Session.Remove(r.Context(), "flash")
+
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return true, err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	stderrors "errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"simple-web-app/pkg/data"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func Test_newTemplateCache(t *testing.T) {
+	cache, err := newTemplateCache(app.Templates)
+	if err != nil {
+		t.Fatal("parsing the templates failed:", err)
+	}
+
+	for _, page := range []string{"home.page.gohtml", "profile.page.gohtml", "error-4xx.page.gohtml", "error-5xx.page.gohtml"} {
+		if cache[page] == nil {
+			t.Errorf("%s is not in the cache", page)
+		}
+	}
+
+	if cache["base.layout.gohtml"] != nil {
+		t.Error("layout is cached as a page")
+	}
+
+	// testdata has a page that uses an undefined variable
+	_, err = newTemplateCache(os.DirFS("./testdata"))
+	if err == nil {
+		t.Error("expected an error from a bad template, but did not get one")
+	}
+}
+
+func TestApp_renderWithBadTemplate(t *testing.T) {
+	var tests = []struct {
+		name string
+		fsys fstest.MapFS
+		page string
+	}{
+		{
+			name: "parse error",
+			fsys: fstest.MapFS{"bad.page.gohtml": {Data: []byte(`{{$nonExistentVar}}`)}},
+			page: "bad.page.gohtml",
+		},
+		{
+			name: "execution error",
+			fsys: fstest.MapFS{"bad.page.gohtml": {Data: []byte(`<p>half a page {{index .Data "list" 5}}</p>`)}},
+			page: "bad.page.gohtml",
+		},
+		{
+			name: "missing page",
+			fsys: fstest.MapFS{},
+			page: "missing.page.gohtml",
+		},
+	}
+
+	saved := app.Templates
+	defer func() {
+		app.Templates = saved
+		app.ReloadTemplates = false
+	}()
+	app.ReloadTemplates = true
+
+	for _, test := range tests {
+		app.Templates = test.fsys
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAndSessionToReq(req, app)
+		rr := httptest.NewRecorder()
+
+		err := app.render(rr, req, test.page, &TemplateData{})
+		if err == nil {
+			t.Errorf("%s: expected error from bad template, but did not get one", test.name)
+		}
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected status 500 but got %d", test.name, rr.Code)
+		}
+
+		if strings.Contains(rr.Body.String(), "half a page") {
+			t.Errorf("%s: part of the page was sent", test.name)
+		}
+	}
+}
+
+func TestApp_renderKeepsMessagesOnError(t *testing.T) {
+	saved := app.Templates
+	defer func() {
+		app.Templates = saved
+		app.ReloadTemplates = false
+	}()
+	app.ReloadTemplates = true
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToReq(req, app)
+	app.Session.Put(req.Context(), "flash", "saved")
+
+	// a page that fails leaves the message for the next page
+	app.Templates = fstest.MapFS{"bad.page.gohtml": {Data: []byte(`{{index .Data "list" 5}}`)}}
+	_ = app.render(httptest.NewRecorder(), req, "bad.page.gohtml", &TemplateData{})
+	if app.Session.GetString(req.Context(), "flash") != "saved" {
+		t.Fatal("the flash message was lost to a failed render")
+	}
+
+	// which shows it once
+	app.Templates = fstest.MapFS{"good.page.gohtml": {Data: []byte(`{{.Flash}}`)}}
+	rr := httptest.NewRecorder()
+	_ = app.render(rr, req, "good.page.gohtml", &TemplateData{})
+	if rr.Body.String() != "saved" {
+		t.Errorf("expected the flash message on the page, got %q", rr.Body.String())
+	}
+	if app.Session.Exists(req.Context(), "flash") {
+		t.Error("the flash message was left in the session after it was shown")
+	}
+}
+
+// brokenConnection is a ResponseWriter whose client has gone away, counting
+// the statuses written to it.
+type brokenConnection struct {
+	*httptest.ResponseRecorder
+	statuses int
+}
+
+func (b *brokenConnection) WriteHeader(status int) {
+	b.statuses++
+	b.ResponseRecorder.WriteHeader(status)
+}
+
+func (b *brokenConnection) Write([]byte) (int, error) {
+	return 0, stderrors.New("connection reset by peer")
+}
+
+func TestApp_renderWriteError(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToReq(req, app)
+	w := &brokenConnection{ResponseRecorder: httptest.NewRecorder()}
+
+	err := app.render(w, req, "home.page.gohtml", &TemplateData{})
+	if err == nil {
+		t.Error("expected the write error, but did not get one")
+	}
+
+	// once the status is sent, there is no error page to send instead
+	if w.statuses != 1 || w.Code != http.StatusOK {
+		t.Errorf("expected status 200 to be written once, got %d written %d times", w.Code, w.statuses)
+	}
+}
+
+func TestApp_renderReloadsTemplates(t *testing.T) {
+	fsys := fstest.MapFS{"test.page.gohtml": {Data: []byte(`first`)}}
+
+	saved := app.Templates
+	defer func() {
+		app.Templates = saved
+		app.ReloadTemplates = false
+	}()
+	app.Templates = fsys
+	app.ReloadTemplates = true
+
+	for _, expected := range []string{"first", "second"} {
+		fsys["test.page.gohtml"].Data = []byte(expected)
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req = addContextAndSessionToReq(req, app)
+		rr := httptest.NewRecorder()
+		_ = app.render(rr, req, "test.page.gohtml", &TemplateData{})
+
+		if rr.Body.String() != expected {
+			t.Errorf("expected %q but got %q", expected, rr.Body.String())
+		}
+	}
+}
+
+func Test_humanDate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		t        time.Time
+		expected string
+	}{
+		{"utc", time.Date(2022, 8, 19, 12, 30, 0, 0, time.UTC), "19 Aug 2022 12:30 UTC"},
+		{"other zone", time.Date(2022, 8, 19, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60)), "19 Aug 2022 12:30 UTC"},
+		{"zero", time.Time{}, ""},
+	}
+
+	for _, test := range tests {
+		if actual := humanDate(test.t); actual != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestApp_renderProfilePicURL(t *testing.T) {
+	var tests = []struct {
+		name        string
+		user        data.User
+		expectedImg string
+	}{
+		{"without variants", data.User{ID: 2, ProfilePic: data.UserImage{FileName: "me.png", StorageKey: "4f1c.png"}}, `src="/static/img/4f1c.png"`},
+		{"with variants", data.User{ID: 2, ProfilePic: data.UserImage{FileName: "me.png", StorageKey: "4f1c.png", Variants: []data.ImageVariant{
+			{Name: "thumbnail", StorageKey: "4f1c-thumbnail.png"},
+			{Name: "medium", StorageKey: "4f1c-medium.png"},
+		}}}, `src="/static/img/4f1c-medium.png"`},
+		{"without picture", data.User{ID: 2}, "No proile image uploaded yet"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/user/profile", nil)
+		req = addContextAndSessionToReq(req, app)
+		app.Session.Put(req.Context(), "user", test.user)
+		rr := httptest.NewRecorder()
+		_ = app.render(rr, req, "profile.page.gohtml", &TemplateData{})
+
+		if !strings.Contains(rr.Body.String(), test.expectedImg) {
+			t.Errorf("%s: did not find %s in the profile page", test.name, test.expectedImg)
+		}
+	}
+}
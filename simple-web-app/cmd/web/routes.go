@@ -14,15 +14,59 @@ func (app *application) routes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.addIPToContext)
 	mux.Use(app.Session.LoadAndSave)
+	mux.Use(app.recoverPanic)
+	mux.Use(app.trackSession)
+	mux.Use(app.limitRequestSize)
+	mux.Use(app.csrf)
 
 	// register routes
 	mux.Get("/", app.Home)
 	mux.Post("/login", app.Login)
+	mux.Post("/logout", app.Logout)
+	mux.Get("/register", app.RegisterPage)
+	mux.Post("/register", app.Register)
+	mux.Get("/verify-email", app.VerifyEmail)
+	mux.Get("/forgot-password", app.ForgotPasswordPage)
+	mux.Post("/forgot-password", app.ForgotPassword)
+	mux.Get("/reset-password", app.ResetPasswordPage)
+	mux.Post("/reset-password", app.ResetPassword)
 
 	mux.Route("/user", func(r chi.Router) {
 		r.Use(app.auth)
 		r.Get("/profile", app.Profile)
+		r.Get("/profile/edit", app.EditProfilePage)
+		r.Post("/profile/edit", app.EditProfile)
+		r.Get("/profile/password", app.ChangePasswordPage)
+		r.Post("/profile/password", app.ChangePassword)
 		r.Post("/upload-profile-pic", app.UploadProfilePic)
+		r.Post("/profile-pic/activate", app.ActivateProfilePic)
+		r.Post("/profile-pic/delete", app.DeleteProfilePic)
+		r.Post("/verify-email", app.ResendVerificationEmail)
+		r.Get("/sessions", app.Sessions)
+		r.Post("/sessions/revoke", app.RevokeSession)
+	})
+
+	mux.Route("/admin", func(r chi.Router) {
+		r.Use(app.auth)
+		r.Use(app.requireAdmin)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		})
+		r.Get("/users", app.AdminUsers)
+		r.Get("/users/new", app.AdminNewUserPage)
+		r.Post("/users/new", app.AdminCreateUser)
+		r.Get("/users/{userID}", app.AdminUserPage)
+		r.Post("/users/{userID}", app.AdminUpdateUser)
+		r.Post("/users/{userID}/admin", app.AdminSetAdmin)
+		r.Post("/users/{userID}/reset-password", app.AdminForcePasswordReset)
+		r.Post("/users/{userID}/delete", app.AdminDeleteUser)
+	})
+
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorPage(w, r, &webError{Status: http.StatusNotFound})
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.errorPage(w, r, &webError{Status: http.StatusMethodNotAllowed, Message: "This page does not accept that kind of request."})
 	})
 
 	// static assets
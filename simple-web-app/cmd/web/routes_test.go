@@ -15,7 +15,33 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{route: "/", method: "GET"},
 		{route: "/login", method: "POST"},
+		{route: "/logout", method: "POST"},
+		{route: "/register", method: "GET"},
+		{route: "/register", method: "POST"},
+		{route: "/verify-email", method: "GET"},
+		{route: "/forgot-password", method: "GET"},
+		{route: "/forgot-password", method: "POST"},
+		{route: "/reset-password", method: "GET"},
+		{route: "/reset-password", method: "POST"},
 		{route: "/user/profile", method: "GET"},
+		{route: "/user/profile/edit", method: "GET"},
+		{route: "/user/profile/edit", method: "POST"},
+		{route: "/user/profile/password", method: "GET"},
+		{route: "/user/profile/password", method: "POST"},
+		{route: "/user/profile-pic/activate", method: "POST"},
+		{route: "/user/profile-pic/delete", method: "POST"},
+		{route: "/user/verify-email", method: "POST"},
+		{route: "/user/sessions", method: "GET"},
+		{route: "/user/sessions/revoke", method: "POST"},
+		{route: "/admin/", method: "GET"},
+		{route: "/admin/users", method: "GET"},
+		{route: "/admin/users/new", method: "GET"},
+		{route: "/admin/users/new", method: "POST"},
+		{route: "/admin/users/{userID}", method: "GET"},
+		{route: "/admin/users/{userID}", method: "POST"},
+		{route: "/admin/users/{userID}/admin", method: "POST"},
+		{route: "/admin/users/{userID}/reset-password", method: "POST"},
+		{route: "/admin/users/{userID}/delete", method: "POST"},
 		{route: "/static/*", method: "GET"},
 	}
 	mux := app.routes()
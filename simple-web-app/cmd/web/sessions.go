@@ -1,14 +1,47 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
+	"simple-web-app/pkg/data"
+	"sort"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 )
 
-func getSession() *scs.SessionManager {
+// session keys describing the device a logged in session is used from, for
+// the sessions page
+const (
+	sessionIPKey        = "ip"
+	sessionUserAgentKey = "user_agent"
+	sessionLoginKey     = "logged_in_at"
+	sessionSeenKey      = "last_seen_at"
+)
+
+// sessionSeenInterval is how often the last use of a session is written back
+// to the store, when nothing else about it changed.
+var sessionSeenInterval = time.Minute
+
+// sessionCleanupInterval is how often expired sessions are deleted from a
+// Postgres session store.
+var sessionCleanupInterval = 5 * time.Minute
+
+// userSessionStore is a session store that knows which user each session is
+// logged in with, so that the sessions of a user are found without decoding
+// every session in the store.
+type userSessionStore interface {
+	scs.Store
+	UserTokens(ctx context.Context, userID int) ([]string, error)
+}
+
+// getSession returns the session manager of the app, keeping sessions in
+// store.
+func getSession(store userSessionStore) *scs.SessionManager {
 	session := scs.New()
+	session.Store = store
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
@@ -16,3 +49,175 @@ func getSession() *scs.SessionManager {
 
 	return session
 }
+
+// DeviceSession describes one of the sessions a user is logged in with.
+type DeviceSession struct {
+	// ID identifies the session without revealing its token.
+	ID         string
+	IP         string
+	UserAgent  string
+	LoggedInAt time.Time
+	LastSeenAt time.Time
+	// Current is set for the session of the request.
+	Current bool
+}
+
+// sessionID derives the public ID of a session from its token.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
+// startUserSession logs user in on a fresh session and CSRF token, to prevent
+// fixation, and notes the device they logged in from.
+func (app *application) startUserSession(r *http.Request, user *data.User) {
+	_ = app.Session.RenewToken(r.Context())
+	// forms rendered before logging in must not work after
+	app.Session.Remove(r.Context(), csrfSessionKey)
+	app.Session.Put(r.Context(), "user", *user)
+	app.Session.Put(r.Context(), sessionLoginKey, time.Now().Unix())
+	app.noteSessionUse(r)
+}
+
+// noteSessionUse records the address and browser the session was used from,
+// and when. To avoid saving the session on every request, the time alone is
+// only updated every sessionSeenInterval.
+func (app *application) noteSessionUse(r *http.Request) {
+	ctx := r.Context()
+	ip := app.ipFromContext(ctx)
+	now := time.Now()
+
+	if app.Session.GetString(ctx, sessionIPKey) == ip &&
+		app.Session.GetString(ctx, sessionUserAgentKey) == r.UserAgent() &&
+		now.Sub(time.Unix(app.Session.GetInt64(ctx, sessionSeenKey), 0)) < sessionSeenInterval {
+		return
+	}
+
+	app.Session.Put(ctx, sessionIPKey, ip)
+	app.Session.Put(ctx, sessionUserAgentKey, r.UserAgent())
+	app.Session.Put(ctx, sessionSeenKey, now.Unix())
+}
+
+// sessionUserID returns the ID of the user logged in with the session in ctx,
+// or 0 if there is none.
+func (app *application) sessionUserID(ctx context.Context) int {
+	return userIDOf(app.Session.Get(ctx, "user"))
+}
+
+// sessionDataUserID returns the ID of the user logged in with the session
+// encoded in b, or 0 if there is none, for the store to index sessions by.
+func sessionDataUserID(b []byte) int {
+	// the session manager uses the default codec
+	_, values, err := scs.GobCodec{}.Decode(b)
+	if err != nil {
+		return 0
+	}
+	return userIDOf(values["user"])
+}
+
+// userIDOf returns the ID of the user stored in a session value.
+func userIDOf(v any) int {
+	if user, ok := v.(data.User); ok {
+		return user.ID
+	}
+	return 0
+}
+
+// findUserSession returns the values of the session with token, if it exists
+// and userID is still logged in with it.
+func (app *application) findUserSession(ctx context.Context, token string, userID int) (map[string]any, bool, error) {
+	var b []byte
+	var found bool
+	var err error
+	if store, ok := app.Session.Store.(scs.CtxStore); ok {
+		b, found, err = store.FindCtx(ctx, token)
+	} else {
+		b, found, err = app.Session.Store.Find(token)
+	}
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	_, values, err := app.Session.Codec.Decode(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return values, userIDOf(values["user"]) == userID, nil
+}
+
+// userSessionTokens returns the tokens of the sessions userID is logged in
+// with.
+func (app *application) userSessionTokens(ctx context.Context, userID int) ([]string, error) {
+	return app.Session.Store.(userSessionStore).UserTokens(ctx, userID)
+}
+
+// userSessions lists the sessions userID is logged in with, most recently
+// used first. The session in ctx is marked as the current one.
+func (app *application) userSessions(ctx context.Context, userID int) ([]DeviceSession, error) {
+	tokens, err := app.userSessionTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := app.Session.Token(ctx)
+	var sessions []DeviceSession
+
+	for _, token := range tokens {
+		values, ok, err := app.findUserSession(ctx, token, userID)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		ip, _ := values[sessionIPKey].(string)
+		userAgent, _ := values[sessionUserAgentKey].(string)
+		loggedInAt, _ := values[sessionLoginKey].(int64)
+		lastSeenAt, _ := values[sessionSeenKey].(int64)
+		sessions = append(sessions, DeviceSession{
+			ID:         sessionID(token),
+			IP:         ip,
+			UserAgent:  userAgent,
+			LoggedInAt: time.Unix(loggedInAt, 0),
+			LastSeenAt: time.Unix(lastSeenAt, 0),
+			Current:    token == current,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+// destroyUserSessions ends the sessions of userID whose ID match accepts,
+// except for the session in ctx, and returns how many it ended.
+func (app *application) destroyUserSessions(ctx context.Context, userID int, match func(id string) bool) (int, error) {
+	tokens, err := app.userSessionTokens(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	current := app.Session.Token(ctx)
+	destroyed := 0
+
+	for _, token := range tokens {
+		if token == current || !match(sessionID(token)) {
+			continue
+		}
+
+		if store, ok := app.Session.Store.(scs.CtxStore); ok {
+			err = store.DeleteCtx(ctx, token)
+		} else {
+			err = app.Session.Store.Delete(token)
+		}
+		if err != nil {
+			return destroyed, err
+		}
+		destroyed++
+	}
+
+	return destroyed, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"simple-web-app/pkg/data"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// storeUserSession saves a session for user, used from ip, and returns its
+// token.
+func storeUserSession(t *testing.T, user data.User, ip string) string {
+	ctx, err := app.Session.Load(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Session.Put(ctx, "user", user)
+	app.Session.Put(ctx, sessionIPKey, ip)
+
+	token, _, err := app.Session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// sessionExists reports whether the store still has the session of token.
+func sessionExists(token string) bool {
+	ctx, _ := app.Session.Load(context.Background(), token)
+	return app.Session.Exists(ctx, "user")
+}
+
+func Test_app_userSessions(t *testing.T) {
+	current := storeUserSession(t, data.User{ID: 10}, "10.0.0.1")
+	other := storeUserSession(t, data.User{ID: 10}, "10.0.0.2")
+	storeUserSession(t, data.User{ID: 11}, "10.0.0.3")
+
+	ctx, _ := app.Session.Load(context.Background(), current)
+	sessions, err := app.userSessions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+
+	for _, s := range sessions {
+		switch s.ID {
+		case sessionID(current):
+			if !s.Current || s.IP != "10.0.0.1" {
+				t.Errorf("unexpected current session %+v", s)
+			}
+		case sessionID(other):
+			if s.Current || s.IP != "10.0.0.2" {
+				t.Errorf("unexpected other session %+v", s)
+			}
+		default:
+			t.Errorf("unexpected session %+v", s)
+		}
+	}
+}
+
+func Test_app_destroyUserSessions(t *testing.T) {
+	current := storeUserSession(t, data.User{ID: 20}, "10.0.0.1")
+	first := storeUserSession(t, data.User{ID: 20}, "10.0.0.2")
+	second := storeUserSession(t, data.User{ID: 20}, "10.0.0.3")
+	stranger := storeUserSession(t, data.User{ID: 21}, "10.0.0.4")
+
+	ctx, _ := app.Session.Load(context.Background(), current)
+
+	n, err := app.destroyUserSessions(ctx, 20, func(id string) bool { return id == sessionID(first) })
+	if err != nil || n != 1 {
+		t.Errorf("expected to end 1 session, ended %d: %v", n, err)
+	}
+	if sessionExists(first) || !sessionExists(second) {
+		t.Error("the wrong session was ended")
+	}
+
+	n, err = app.destroyUserSessions(ctx, 20, func(string) bool { return true })
+	if err != nil || n != 1 {
+		t.Errorf("expected to end 1 session, ended %d: %v", n, err)
+	}
+
+	if !sessionExists(current) {
+		t.Error("the current session was ended")
+	}
+	if sessionExists(second) {
+		t.Error("other session was not ended")
+	}
+	if !sessionExists(stranger) {
+		t.Error("session of another user was ended")
+	}
+}
+
+func Test_sessionDataUserID(t *testing.T) {
+	var tests = []struct {
+		name     string
+		values   map[string]any
+		expected int
+	}{
+		{"user", map[string]any{"user": data.User{ID: 5}}, 5},
+		{"anonymous", map[string]any{"flash": "hello"}, 0},
+	}
+
+	for _, test := range tests {
+		b, err := scs.GobCodec{}.Encode(time.Now().Add(time.Hour), test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id := sessionDataUserID(b); id != test.expected {
+			t.Errorf("%s: expected user %d, but got %d", test.name, test.expected, id)
+		}
+	}
+
+	if id := sessionDataUserID([]byte("not a session")); id != 0 {
+		t.Errorf("expected no user for undecodable data, but got %d", id)
+	}
+}
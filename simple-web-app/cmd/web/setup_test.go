@@ -1,13 +1,48 @@
 package main
 
 import (
+	"context"
+	"encoding/gob"
+	"log"
+	"net/http"
 	"os"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/mailer"
 	"simple-web-app/pkg/repository/dbrepo"
+	"simple-web-app/pkg/storage"
+	"simple-web-app/templates"
+	"sync"
 	"testing"
 )
 
 var app application
 
+// testMailer keeps the messages the app sends, so tests can follow the links
+// in them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// last returns the message sent most recently, and whether there was one.
+func (m *testMailer) last() (mailer.Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		return mailer.Message{}, false
+	}
+	return m.sent[len(m.sent)-1], true
+}
+
+var mail = &testMailer{}
+
 /*
 This function test main is it will always be executed before the actual tests run.
 So goes tooling will actually look for the existence of a setup, underscore Tesco file and look for
@@ -19,9 +54,44 @@ implement sessions, configure database, all of that
 configuration right here in the test main function.
 */
 func TestMain(m *testing.M) {
-	pathToTemplates = "./../../templates/"
-	app.Session = getSession()
-	app.DB = &dbrepo.TestDBRepo{}
+	gob.Register(data.User{})
+	app.Templates = templates.FS
+	cache, err := newTemplateCache(app.Templates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.TemplateCache = cache
+	sessions := dbrepo.NewMemorySessionStore()
+	sessions.UserID = sessionDataUserID
+	app.Session = getSession(sessions)
+	app.Mailer = mail
+	app.BaseURL = "http://localhost:8080"
+	app.Uploads = defaultUploadConfig
+	app.Storage = &storage.Filesystem{Dir: "./testdata/uploads", BaseURL: "/static/img"}
+	resetDB()
 
 	os.Exit(m.Run())
 }
+
+// resetDB gives the app a fresh in-memory database, seeded with an admin
+// (id 1) and a regular user (id 2), both with the password "secret".
+func resetDB() {
+	repo := dbrepo.NewMemoryDBRepo()
+	if err := repo.LoadFixtures("./../../fixtures/users.json"); err != nil {
+		log.Fatal(err)
+	}
+	app.DB = repo
+}
+
+// issueCSRFToken gives the session of req a CSRF token and returns it, to send
+// back in the csrf_token form field.
+func issueCSRFToken(req *http.Request) string {
+	return app.csrfToken(req.Context())
+}
+
+// withCSRFToken sends the CSRF token of the session of req along with it, in
+// the X-CSRF-Token header, so that it passes the csrf middleware.
+func withCSRFToken(req *http.Request) *http.Request {
+	req.Header.Set(csrfHeader, issueCSRFToken(req))
+	return req
+}
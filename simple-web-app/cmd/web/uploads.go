@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/imaging"
+	"sort"
+	"strings"
+)
+
+// imageTypes are the image formats uploads may have, by content type as
+// sniffed by http.DetectContentType, with the extension files are stored with.
+// They are the formats the imaging package can decode.
+var imageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// UploadConfig limits what UploadFiles accepts.
+type UploadConfig struct {
+	// MaxFileSize is the size limit of each file, in bytes.
+	MaxFileSize int64
+	// MaxFiles is how many files one request may upload.
+	MaxFiles int
+	// AllowedTypes are the content types files may have, from imageTypes.
+	AllowedTypes []string
+	// MaxPixels is the size limit of each image once decoded, in pixels.
+	MaxPixels int
+	// Variants are the sizes images are scaled down to, on top of keeping
+	// them at their full size.
+	Variants []imaging.Variant
+}
+
+var defaultUploadConfig = UploadConfig{
+	MaxFileSize:  5 << 20,
+	MaxFiles:     1,
+	AllowedTypes: []string{"image/png", "image/jpeg", "image/gif"},
+	MaxPixels:    25_000_000,
+	Variants: []imaging.Variant{
+		{Name: "thumbnail", Width: 64, Height: 64},
+		{Name: "medium", Width: 400, Height: 400},
+	},
+}
+
+// formOverhead is what the fields of a form may add to the size of its body,
+// on top of the files.
+const formOverhead = 1 << 20
+
+// MaxRequestSize is the largest request body that can carry MaxFiles files.
+func (c UploadConfig) MaxRequestSize() int64 {
+	return int64(c.MaxFiles)*c.MaxFileSize + formOverhead
+}
+
+func (c UploadConfig) allows(contentType string) bool {
+	for _, allowed := range c.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseUploadTypes turns a comma separated list of image formats, e.g.
+// "png,jpeg", into content types.
+func parseUploadTypes(list string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(list, ",") {
+		contentType := "image/" + strings.TrimSpace(strings.ToLower(name))
+		if _, ok := imageTypes[contentType]; !ok {
+			return nil, fmt.Errorf("unsupported image format %q", name)
+		}
+		types = append(types, contentType)
+	}
+	return types, nil
+}
+
+// errRequestTooLarge is returned when reading more of a request body than
+// limitRequestSize allows.
+var errRequestTooLarge = stderrors.New("request body too large")
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// parseForm parses the body of r, multipart or not, with an error page for
+// bodies that are too large or can't be read.
+func parseForm(r *http.Request) error {
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// larger files go to temporary files, which MultipartForm.RemoveAll
+		// deletes once the request is done
+		err = r.ParseMultipartForm(formOverhead)
+	} else {
+		err = r.ParseForm()
+	}
+
+	if stderrors.Is(err, errRequestTooLarge) {
+		return &webError{Status: http.StatusRequestEntityTooLarge, Message: "The upload is too big.", Err: err}
+	} else if err != nil {
+		return badRequest(err)
+	}
+	return nil
+}
+
+type UploadedFile struct {
+	// OriginalFileName is the name the client sent, without any directories.
+	// It is not used to store the file.
+	OriginalFileName string
+	// Key is the unique key the file is stored under in app.Storage.
+	Key string
+	// ContentType and FileSize describe the file as stored, which is not
+	// the file as uploaded: it is encoded again, without its metadata.
+	ContentType string
+	FileSize    int64
+	// Variants are the scaled down copies stored along with the file.
+	Variants []data.ImageVariant
+}
+
+// storageKeys returns the keys of the file and its variants.
+func (f *UploadedFile) storageKeys() []string {
+	keys := []string{f.Key}
+	for _, v := range f.Variants {
+		keys = append(keys, v.StorageKey)
+	}
+	return keys
+}
+
+// maxFileNameLength is the longest original file name kept, the size of the
+// file_name column.
+const maxFileNameLength = 255
+
+// UploadFiles stores the files uploaded with r in app.Storage, after checking
+// them against app.Uploads. It stores all files or none.
+func (app *application) UploadFiles(r *http.Request) ([]*UploadedFile, error) {
+	if r.MultipartForm == nil {
+		if err := parseForm(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.MultipartForm == nil {
+		return nil, &webError{Status: http.StatusBadRequest, Message: "Files have to be uploaded with a multipart form."}
+	}
+
+	// in the order of the form fields, for a predictable result
+	var fields []string
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var headers []*multipart.FileHeader
+	for _, field := range fields {
+		headers = append(headers, r.MultipartForm.File[field]...)
+	}
+
+	if len(headers) > app.Uploads.MaxFiles {
+		return nil, &webError{Status: http.StatusBadRequest, Message: fmt.Sprintf("At most %d file(s) can be uploaded at once.", app.Uploads.MaxFiles)}
+	}
+
+	var uploadedFiles []*UploadedFile
+	for _, hdr := range headers {
+		uploadedFile, err := app.saveUpload(r.Context(), hdr)
+		if err != nil {
+			app.removeUploads(r.Context(), uploadedFiles)
+			return nil, err
+		}
+		uploadedFiles = append(uploadedFiles, uploadedFile)
+	}
+
+	return uploadedFiles, nil
+}
+
+// saveUpload stores one uploaded image under a new, unique key, if it is of an
+// allowed type and not too large, along with its variants.
+func (app *application) saveUpload(ctx context.Context, hdr *multipart.FileHeader) (*UploadedFile, error) {
+	if hdr.Size > app.Uploads.MaxFileSize {
+		return nil, &webError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("%s is too big, images can be at most %s.", filepath.Base(hdr.Filename), app.Uploads.describeMaxFileSize()),
+		}
+	}
+
+	infile, err := hdr.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer infile.Close()
+
+	// the size the client claims may be wrong too; files are small enough to
+	// be held in memory, which the S3 store needs anyway
+	content, err := io.ReadAll(io.LimitReader(infile, app.Uploads.MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > app.Uploads.MaxFileSize {
+		return nil, &webError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Images can be at most %s.", app.Uploads.describeMaxFileSize())}
+	}
+
+	// don't trust the content type the client claims, look at the content
+	contentType := http.DetectContentType(content)
+	if !app.Uploads.allows(contentType) {
+		return nil, &webError{
+			Status:  http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("%s is not a supported image; please upload %s.", filepath.Base(hdr.Filename), app.Uploads.describeTypes()),
+		}
+	}
+
+	img, err := imaging.Decode(content, app.Uploads.MaxPixels)
+	if stderrors.Is(err, imaging.ErrTooLarge) {
+		return nil, &webError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("%s has too many pixels; please upload a smaller image.", filepath.Base(hdr.Filename)),
+			Err:     err,
+		}
+	} else if err != nil {
+		return nil, &webError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("%s could not be read as an image.", filepath.Base(hdr.Filename)),
+			Err:     err,
+		}
+	}
+
+	// a random name of 128 bits never replaces another image
+	name, err := randomFileName("")
+	if err != nil {
+		return nil, err
+	}
+	ext := imageTypes[img.ContentType()]
+
+	originalName := filepath.Base(hdr.Filename)
+	if len(originalName) > maxFileNameLength {
+		originalName = strings.ToValidUTF8(originalName[:maxFileNameLength], "")
+	}
+
+	uploadedFile := &UploadedFile{
+		OriginalFileName: originalName,
+		Key:              name + ext,
+		ContentType:      img.ContentType(),
+	}
+
+	uploadedFile.FileSize, err = app.storeImage(ctx, uploadedFile.Key, img)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range app.Uploads.Variants {
+		scaled := img.Fit(v.Width, v.Height)
+		if scaled == img {
+			// the image is small enough to be its own variant
+			continue
+		}
+
+		key := name + "-" + v.Name + ext
+		if _, err := app.storeImage(ctx, key, scaled); err != nil {
+			app.removeUploads(ctx, []*UploadedFile{uploadedFile})
+			return nil, err
+		}
+
+		uploadedFile.Variants = append(uploadedFile.Variants, data.ImageVariant{
+			Name:       v.Name,
+			StorageKey: key,
+			Width:      scaled.Width(),
+			Height:     scaled.Height(),
+		})
+	}
+
+	return uploadedFile, nil
+}
+
+// storeImage encodes img and stores it under key, returning its size.
+func (app *application) storeImage(ctx context.Context, key string, img *imaging.Image) (int64, error) {
+	buf := new(bytes.Buffer)
+	if err := img.Encode(buf); err != nil {
+		return 0, err
+	}
+
+	size := int64(buf.Len())
+	return size, app.Storage.Put(ctx, key, buf, img.ContentType())
+}
+
+// imageURLs returns the URLs of img and its variants, by the names of the
+// variants of app.Uploads and "original" for img itself. Variants img lacks
+// are the URL of img.
+func (app *application) imageURLs(ctx context.Context, img data.UserImage) (map[string]string, error) {
+	urls := map[string]string{}
+
+	var err error
+	urls["original"], err = app.Storage.URL(ctx, img.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range app.Uploads.Variants {
+		urls[v.Name], err = app.Storage.URL(ctx, img.VariantKey(v.Name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return urls, nil
+}
+
+// describeTypes lists the allowed formats for people, e.g. "PNG or JPEG".
+func (c UploadConfig) describeTypes() string {
+	var names []string
+	for _, contentType := range c.AllowedTypes {
+		names = append(names, strings.ToUpper(strings.TrimPrefix(contentType, "image/")))
+	}
+
+	if len(names) <= 1 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// describeMaxFileSize gives the largest allowed file for people, e.g. "5 MB",
+// in the largest unit the size is a whole number of.
+func (c UploadConfig) describeMaxFileSize() string {
+	switch {
+	case c.MaxFileSize >= 1<<20 && c.MaxFileSize%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", c.MaxFileSize>>20)
+	case c.MaxFileSize >= 1<<10 && c.MaxFileSize%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", c.MaxFileSize>>10)
+	default:
+		return fmt.Sprintf("%d bytes", c.MaxFileSize)
+	}
+}
+
+// randomFileName returns a random file name with the given extension.
+func randomFileName(ext string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + ext, nil
+}
+
+// removeUploads deletes uploaded files, and their variants, that won't be
+// used after all.
+func (app *application) removeUploads(ctx context.Context, files []*UploadedFile) {
+	for _, f := range files {
+		app.deleteImages(ctx, f.storageKeys())
+	}
+}
+
+// deleteImages deletes the images stored under keys. Failures are only
+// logged, since a left over file does no harm.
+func (app *application) deleteImages(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := app.Storage.Delete(ctx, key); err != nil {
+			log.Printf("removing image %s: %s", key, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"simple-web-app/pkg/data"
+	"strings"
+	"testing"
+)
+
+// storedUploads lists the files tests uploaded to testdata/uploads.
+func storedUploads() []string {
+	var stored []string
+	files, _ := filepath.Glob("./testdata/uploads/*")
+	for _, f := range files {
+		if filepath.Base(f) != ".gitkeep" {
+			stored = append(stored, f)
+		}
+	}
+	return stored
+}
+
+// cleanUploads removes the files tests uploaded to testdata/uploads.
+func cleanUploads() {
+	for _, f := range storedUploads() {
+		_ = os.Remove(f)
+	}
+}
+
+// uploadFile is a file in a multipart upload.
+type uploadFile struct {
+	name    string
+	content []byte
+}
+
+// uploadRequest returns a request uploading files in the "image" field.
+func uploadRequest(files ...uploadFile) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, f := range files {
+		w, _ := mw.CreateFormFile("image", f.name)
+		_, _ = w.Write(f.content)
+	}
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/user/upload-profile-pic", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func Test_app_UploadFilesChecks(t *testing.T) {
+	png, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name           string
+		files          []uploadFile
+		maxFileSize    int64
+		maxPixels      int
+		expectedStatus int
+	}{
+		{"png", []uploadFile{{"img.png", png}}, 5 << 20, 1 << 20, 0},
+		{"path in file name", []uploadFile{{"../../img.png", png}}, 5 << 20, 1 << 20, 0},
+		{"text claiming to be png", []uploadFile{{"img.png", []byte("just some text")}}, 5 << 20, 1 << 20, http.StatusUnsupportedMediaType},
+		{"html", []uploadFile{{"img.png", []byte("<html><script>alert(1)</script></html>")}}, 5 << 20, 1 << 20, http.StatusUnsupportedMediaType},
+		{"broken png", []uploadFile{{"img.png", png[:100]}}, 5 << 20, 1 << 20, http.StatusUnprocessableEntity},
+		{"too big", []uploadFile{{"img.png", png}}, 100, 1 << 20, http.StatusRequestEntityTooLarge},
+		{"too many pixels", []uploadFile{{"img.png", png}}, 5 << 20, 200 * 200, http.StatusRequestEntityTooLarge},
+		{"too many files", []uploadFile{{"a.png", png}, {"b.png", png}}, 5 << 20, 1 << 20, http.StatusBadRequest},
+	}
+
+	defer func() { app.Uploads = defaultUploadConfig }()
+
+	for _, test := range tests {
+		app.Uploads.MaxFileSize = test.maxFileSize
+		app.Uploads.MaxPixels = test.maxPixels
+
+		files, err := app.UploadFiles(uploadRequest(test.files...))
+		stored := storedUploads()
+
+		if test.expectedStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: upload failed: %s", test.name, err)
+			} else if len(stored) != len(files[0].storageKeys()) {
+				t.Errorf("%s: expected %v to be stored, found %v", test.name, files[0].storageKeys(), stored)
+			} else if !strings.HasSuffix(files[0].Key, ".png") || files[0].Key == "img.png" {
+				t.Errorf("%s: stored under unexpected key %s", test.name, files[0].Key)
+			} else if files[0].OriginalFileName != "img.png" {
+				t.Errorf("%s: expected original name img.png, but got %s", test.name, files[0].OriginalFileName)
+			}
+		} else {
+			if page := errorPageFor(err); err == nil || page.Status != test.expectedStatus {
+				t.Errorf("%s: expected status %d, but got error %v", test.name, test.expectedStatus, err)
+			}
+			if len(stored) != 0 {
+				t.Errorf("%s: rejected upload left files behind: %v", test.name, stored)
+			}
+		}
+
+		cleanUploads()
+	}
+
+	if _, err := os.Stat("./img.png"); err == nil {
+		t.Error("upload was stored outside the upload directory")
+	}
+}
+
+func Test_app_UploadProfilePicKeepsHistory(t *testing.T) {
+	defer cleanUploads()
+	defer resetDB()
+
+	png, _ := os.ReadFile("./testdata/img.png")
+
+	var keys []string
+	for i := 0; i < 2; i++ {
+		req := addContextAndSessionToReq(uploadRequest(uploadFile{"img.png", png}), app)
+		app.Session.Put(req.Context(), "user", data.User{ID: 2})
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.UploadProfilePic).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("upload %d: expected status 303 but got %d", i+1, rr.Code)
+		}
+
+		if user, ok := app.Session.Get(req.Context(), "user").(data.User); !ok || user.ProfilePic.StorageKey == "" {
+			t.Errorf("upload %d: the session user was not refreshed", i+1)
+		}
+
+		user, _ := app.DB.GetUser(context.Background(), 2)
+		keys = append(keys, user.ProfilePic.StorageKey)
+	}
+
+	if keys[0] == keys[1] {
+		t.Fatalf("expected the second upload to be the profile picture, but got %s", keys[1])
+	}
+
+	images, err := app.DB.ListUserImages(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 || images[0].StorageKey != keys[1] || !images[0].Active || images[1].Active {
+		t.Errorf("expected the new image to be active and the old one kept, but got %v", images)
+	}
+
+	// both images and their thumbnails
+	if stored := storedUploads(); len(stored) != 4 {
+		t.Errorf("expected both images and their thumbnails to be kept, found %v", stored)
+	}
+}
+
+func Test_app_limitRequestSize(t *testing.T) {
+	handler := app.limitRequestSize(app.csrf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	var tests = []struct {
+		name           string
+		size           int64
+		expectedStatus int
+	}{
+		{"small", 1 << 10, http.StatusOK},
+		{"too large", defaultUploadConfig.MaxRequestSize() + 1, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/user/upload-profile-pic", nil)
+		req = addContextAndSessionToReq(req, app)
+
+		// send the token in the form, so the middleware has to read the body
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		_ = mw.WriteField("csrf_token", issueCSRFToken(req))
+		w, _ := mw.CreateFormFile("image", "img.png")
+		_, _ = w.Write(bytes.Repeat([]byte{0}, int(test.size)))
+		_ = mw.Close()
+		req.Body = io.NopCloser(body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", test.name, test.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func Test_app_UploadFilesVariants(t *testing.T) {
+	defer cleanUploads()
+
+	// a photo, with the location it was taken at
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, src, nil)
+	exif := "Exif\x00\x00GPS 52.5200N 13.4050E"
+	photo := append([]byte{0xff, 0xd8, 0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	photo = append(photo, buf.Bytes()[2:]...)
+
+	files, err := app.UploadFiles(uploadRequest(uploadFile{"photo.jpg", photo}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files[0].ContentType != "image/jpeg" || !strings.HasSuffix(files[0].Key, ".jpg") {
+		t.Errorf("expected a JPEG to be stored, but got %s as %s", files[0].ContentType, files[0].Key)
+	}
+
+	expected := map[string]image.Point{"original": {800, 400}, "thumbnail": {64, 32}, "medium": {400, 200}}
+	keys := map[string]string{"original": files[0].Key}
+	for _, v := range files[0].Variants {
+		keys[v.Name] = v.StorageKey
+		if (image.Point{v.Width, v.Height}) != expected[v.Name] {
+			t.Errorf("%s: expected size %v, but recorded %dx%d", v.Name, expected[v.Name], v.Width, v.Height)
+		}
+	}
+
+	for name, size := range expected {
+		content, err := os.ReadFile(filepath.Join("./testdata/uploads", keys[name]))
+		if err != nil {
+			t.Errorf("%s: not stored: %s", name, err)
+			continue
+		}
+
+		if bytes.Contains(content, []byte("GPS")) {
+			t.Errorf("%s: the EXIF block was kept", name)
+		}
+
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if err != nil || (image.Point{cfg.Width, cfg.Height}) != size {
+			t.Errorf("%s: expected a %v image, but got %dx%d %v", name, size, cfg.Width, cfg.Height, err)
+		}
+	}
+}
+
+func Test_parseUploadTypes(t *testing.T) {
+	var tests = []struct {
+		name        string
+		list        string
+		expected    string
+		expectError bool
+	}{
+		{"one", "png", "image/png", false},
+		{"several", "PNG, jpeg,gif", "image/png,image/jpeg,image/gif", false},
+		{"unknown", "png,svg", "", true},
+		{"not decodable", "png,webp", "", true},
+	}
+
+	for _, test := range tests {
+		types, err := parseUploadTypes(test.list)
+		if (err != nil) != test.expectError {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if strings.Join(types, ",") != test.expected && !test.expectError {
+			t.Errorf("%s: expected %s but got %v", test.name, test.expected, types)
+		}
+	}
+}
+
+func Test_UploadConfig_describeMaxFileSize(t *testing.T) {
+	var tests = []struct {
+		name     string
+		size     int64
+		expected string
+	}{
+		{"megabytes", 5 << 20, "5 MB"},
+		{"part of a megabyte", 1536 << 10, "1536 KB"},
+		{"kilobytes", 512 << 10, "512 KB"},
+		{"bytes", 1000, "1000 bytes"},
+	}
+
+	for _, test := range tests {
+		if got := (UploadConfig{MaxFileSize: test.size}).describeMaxFileSize(); got != test.expected {
+			t.Errorf("%s: expected %q, but got %q", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// RefreshToken is the server side record of an issued refresh token. Every
+// token issued by rotating another one shares its FamilyID, so that a whole
+// chain can be revoked at once when reuse of an old token is detected.
+type RefreshToken struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	FamilyID   string    `json:"family_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Revoked    bool      `json:"revoked"`
+	ReplacedBy string    `json:"replaced_by"`
+	CreatedAt  time.Time `json:"-"`
+}
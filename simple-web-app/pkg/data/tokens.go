@@ -0,0 +1,50 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"time"
+)
+
+// The scopes a Token can be issued for. A token is only accepted for the
+// scope it was issued for.
+const (
+	ScopeVerifyEmail   = "verify_email"
+	ScopePasswordReset = "password_reset"
+)
+
+// Token is a single use secret that is sent to a user by email, e.g. to
+// verify their address or reset their password. Only the SHA-256 hash of the
+// plain text is stored, so a leaked table does not leak usable tokens.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int       `json:"-"`
+	Scope     string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GenerateToken returns a new random token for userID, valid for ttl.
+func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return &Token{
+		Plaintext: plaintext,
+		Hash:      HashToken(plaintext),
+		UserID:    userID,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// HashToken returns the hash a token with the given plain text is stored under.
+func HashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
@@ -2,11 +2,48 @@ package data
 
 import "time"
 
-// UserImage is the type for user profile images.
+// UserImage is the type for user profile images. Users keep the images they
+// uploaded before; the active one is their profile picture.
 type UserImage struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	FileName  string    `json:"file_name"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	// FileName is the name the image was uploaded with.
+	FileName string `json:"file_name"`
+	// StorageKey is the key the image is kept under in the image store.
+	StorageKey string `json:"storage_key"`
+	// Variants are the scaled down copies of the image.
+	Variants  []ImageVariant `json:"variants"`
+	Active    bool           `json:"active"`
+	CreatedAt time.Time      `json:"-"`
+	UpdatedAt time.Time      `json:"-"`
+}
+
+// ImageVariant is a copy of an image scaled down to fit a size, such as the
+// thumbnail.
+type ImageVariant struct {
+	Name       string `json:"name"`
+	StorageKey string `json:"storage_key"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+// VariantKey returns the storage key of the variant name of the image, or of
+// the image itself if it has no such variant, e.g. because it was uploaded
+// before variants were made, or was smaller than the variant anyway.
+func (i UserImage) VariantKey(name string) string {
+	for _, v := range i.Variants {
+		if v.Name == name {
+			return v.StorageKey
+		}
+	}
+	return i.StorageKey
+}
+
+// StorageKeys returns the keys of the image and all of its variants.
+func (i UserImage) StorageKeys() []string {
+	keys := []string{i.StorageKey}
+	for _, v := range i.Variants {
+		keys = append(keys, v.StorageKey)
+	}
+	return keys
 }
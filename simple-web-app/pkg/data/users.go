@@ -15,6 +15,7 @@ type User struct {
 	Email      string    `json:"email"`
 	Password   string    `json:"-"`
 	IsAdmin    int       `json:"is_admin"`
+	Verified   bool      `json:"verified"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 	ProfilePic UserImage `json:"-"`
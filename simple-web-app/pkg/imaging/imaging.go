@@ -0,0 +1,218 @@
+// Package imaging prepares uploaded images for showing them: it decodes them
+// with the standard image packages, turns photos upright, scales them down
+// and encodes them again. Encoding a decoded image leaves out the metadata of
+// the uploaded file, such as the EXIF block with the camera and the location a
+// photo was taken at.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnsupported is returned for data that is not an image in one of
+	// the formats Decode reads.
+	ErrUnsupported = errors.New("imaging: unsupported image")
+	// ErrTooLarge is returned for images with more pixels than allowed.
+	ErrTooLarge = errors.New("imaging: image too large")
+)
+
+// jpegQuality is the quality JPEG images are encoded with.
+const jpegQuality = 85
+
+// Image is a decoded image, the right way up.
+type Image struct {
+	// Format is the format the image was decoded from: png, jpeg or gif.
+	Format string
+	img    *image.RGBA
+}
+
+// Decode reads a PNG, JPEG or GIF image from data, of which GIFs only keep
+// their first frame. Images with more than maxPixels pixels are rejected
+// before decoding them, since a small file can describe a huge image.
+func Decode(data []byte, maxPixels int) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels || cfg.Width > maxPixels || cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var src image.Image
+	switch format {
+	case "png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+	case "gif":
+		src, err = gif.Decode(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, err)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	if format == "jpeg" {
+		rgba = orient(rgba, jpegOrientation(data))
+	}
+
+	return &Image{Format: format, img: rgba}, nil
+}
+
+// Width is the width of the image, in pixels.
+func (i *Image) Width() int {
+	return i.img.Bounds().Dx()
+}
+
+// Height is the height of the image, in pixels.
+func (i *Image) Height() int {
+	return i.img.Bounds().Dy()
+}
+
+// ContentType is the type of what Encode writes. GIFs are encoded as PNGs,
+// since only their first frame is kept.
+func (i *Image) ContentType() string {
+	if i.Format == "jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Encode writes the image as a JPEG if it was decoded from one, and as a PNG
+// otherwise.
+func (i *Image) Encode(w io.Writer) error {
+	if i.Format == "jpeg" {
+		return jpeg.Encode(w, i.img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return png.Encode(w, i.img)
+}
+
+// Fit returns the image scaled down to fit into width by height pixels,
+// keeping its aspect ratio. Images that already fit are returned as they are,
+// never scaled up.
+func (i *Image) Fit(width, height int) *Image {
+	w, h := i.Width(), i.Height()
+	if w <= width && h <= height {
+		return i
+	}
+
+	// scale by whichever side is further over its limit
+	if w*height > h*width {
+		w, h = width, (h*width+w/2)/w
+	} else {
+		w, h = (w*height+h/2)/h, height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	return &Image{Format: i.Format, img: resize(i.img, w, h)}
+}
+
+// resize scales src to width by height pixels, each of which is the average
+// of the pixels of src it covers. Since RGBA is premultiplied, averaging
+// treats transparent pixels right.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		sy0, sy1 := span(y, sh, height)
+		for x := 0; x < width; x++ {
+			sx0, sx1 := span(x, sw, width)
+
+			var sum [4]int
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride+sx0*4 : sy*src.Stride+sx1*4]
+				for p := 0; p < len(row); p += 4 {
+					sum[0] += int(row[p])
+					sum[1] += int(row[p+1])
+					sum[2] += int(row[p+2])
+					sum[3] += int(row[p+3])
+				}
+			}
+
+			n := (sy1 - sy0) * (sx1 - sx0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for c := 0; c < 4; c++ {
+				d[c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// span returns the pixels of a side of n pixels that pixel i covers when the
+// side is scaled to m pixels; at least one.
+func span(i, n, m int) (int, int) {
+	from, to := i*n/m, (i+1)*n/m
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
+
+// Variant is a size images are scaled down to, to show them smaller.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+// variantName is what variant names may look like, since they end up in
+// storage keys.
+var variantName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ParseVariants parses a comma separated list of variants, each a name with
+// the box images are fit into, e.g. "thumbnail=64x64,medium=400x400".
+func ParseVariants(list string) ([]Variant, error) {
+	var variants []Variant
+	seen := map[string]bool{}
+
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		name, size, ok := strings.Cut(spec, "=")
+		width, height, ok2 := strings.Cut(size, "x")
+		w, err := strconv.Atoi(width)
+		h, err2 := strconv.Atoi(height)
+		if !ok || !ok2 || err != nil || err2 != nil || !variantName.MatchString(name) || w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("invalid image variant %q, expected name=WIDTHxHEIGHT", spec)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("image variant %q is given twice", name)
+		}
+		seen[name] = true
+
+		variants = append(variants, Variant{Name: name, Width: w, Height: h})
+	}
+
+	return variants, nil
+}
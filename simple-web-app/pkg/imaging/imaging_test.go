@@ -0,0 +1,264 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// halves returns a width by height image, red on the left and blue on the
+// right.
+func halves(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func encodeJPEG(img image.Image) []byte {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	return buf.Bytes()
+}
+
+func encodeGIF(img image.Image) []byte {
+	var buf bytes.Buffer
+	_ = gif.Encode(&buf, img, nil)
+	return buf.Bytes()
+}
+
+// withEXIF adds an EXIF block to a JPEG file, with orientation and a note
+// like the ones that give away where a photo was taken.
+func withEXIF(jpg []byte, orientation uint16, note string) []byte {
+	be := binary.BigEndian
+
+	// one directory entry: the orientation, a SHORT
+	entry := make([]byte, 12)
+	be.PutUint16(entry, orientationTag)
+	be.PutUint16(entry[2:], 3)
+	be.PutUint32(entry[4:], 1)
+	be.PutUint16(entry[8:], orientation)
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, note...)
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	be.PutUint16(segment[2:], uint16(len(app1)+2))
+	segment = append(segment, app1...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+// near reports whether c is close to expected, allowing for JPEG losing
+// some detail.
+func near(c color.Color, expected color.RGBA) bool {
+	r, g, b, _ := c.RGBA()
+	diff := func(a uint32, b uint8) bool {
+		d := int(a>>8) - int(b)
+		return d > -40 && d < 40
+	}
+	return diff(r, expected.R) && diff(g, expected.G) && diff(b, expected.B)
+}
+
+func TestDecode(t *testing.T) {
+	var tests = []struct {
+		name           string
+		data           []byte
+		maxPixels      int
+		expectedFormat string
+		expectedErr    error
+	}{
+		{"png", encodePNG(halves(16, 8)), 1000, "png", nil},
+		{"jpeg", encodeJPEG(halves(16, 8)), 1000, "jpeg", nil},
+		{"gif", encodeGIF(halves(16, 8)), 1000, "gif", nil},
+		{"text", []byte("just some text"), 1000, "", ErrUnsupported},
+		{"truncated png", encodePNG(halves(16, 8))[:60], 1000, "", ErrUnsupported},
+		{"too many pixels", encodePNG(halves(16, 8)), 100, "", ErrTooLarge},
+	}
+
+	for _, test := range tests {
+		img, err := Decode(test.data, test.maxPixels)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("%s: expected error %v, but got %v", test.name, test.expectedErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if img.Format != test.expectedFormat || img.Width() != 16 || img.Height() != 8 {
+			t.Errorf("%s: expected a 16x8 %s image, but got a %dx%d %s image", test.name, test.expectedFormat, img.Width(), img.Height(), img.Format)
+		}
+	}
+}
+
+func TestDecode_orientation(t *testing.T) {
+	var tests = []struct {
+		orientation uint16
+		width       int
+		height      int
+		// where the red half ends up
+		redAt image.Point
+	}{
+		{1, 16, 8, image.Pt(2, 4)},
+		{3, 16, 8, image.Pt(13, 4)},
+		{6, 8, 16, image.Pt(4, 2)},
+		{8, 8, 16, image.Pt(4, 13)},
+	}
+
+	for _, test := range tests {
+		img, err := Decode(withEXIF(encodeJPEG(halves(16, 8)), test.orientation, ""), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.Width() != test.width || img.Height() != test.height {
+			t.Errorf("orientation %d: expected %dx%d, but got %dx%d", test.orientation, test.width, test.height, img.Width(), img.Height())
+			continue
+		}
+
+		if c := img.img.At(test.redAt.X, test.redAt.Y); !near(c, red) {
+			t.Errorf("orientation %d: expected red at %v, but got %v", test.orientation, test.redAt, c)
+		}
+	}
+}
+
+func TestImage_EncodeStripsMetadata(t *testing.T) {
+	img, err := Decode(withEXIF(encodeJPEG(halves(16, 8)), 1, "GPS 52.52N 13.40E"), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := img.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("Exif")) || bytes.Contains(buf.Bytes(), []byte("GPS")) {
+		t.Error("the EXIF block was kept")
+	}
+
+	if _, format, err := image.Decode(&buf); err != nil || format != "jpeg" {
+		t.Errorf("expected a JPEG, but got %s %v", format, err)
+	}
+}
+
+func TestImage_Fit(t *testing.T) {
+	var tests = []struct {
+		name           string
+		width, height  int
+		boxW, boxH     int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"wide", 400, 200, 100, 100, 100, 50},
+		{"tall", 200, 400, 64, 64, 32, 64},
+		{"already fits", 50, 30, 64, 64, 50, 30},
+		{"thin line", 1000, 1, 10, 10, 10, 1},
+	}
+
+	for _, test := range tests {
+		img := &Image{Format: "png", img: halves(test.width, test.height)}
+		fit := img.Fit(test.boxW, test.boxH)
+
+		if fit.Width() != test.expectedWidth || fit.Height() != test.expectedHeight {
+			t.Errorf("%s: expected %dx%d, but got %dx%d", test.name, test.expectedWidth, test.expectedHeight, fit.Width(), fit.Height())
+		}
+	}
+
+	// the halves stay red and blue, and the pixel between them is a mix
+	fit := (&Image{Format: "png", img: halves(400, 200)}).Fit(3, 3)
+	if c := fit.img.RGBAAt(0, 1); c != red {
+		t.Errorf("expected red on the left, but got %v", c)
+	}
+	if c := fit.img.RGBAAt(2, 1); c != blue {
+		t.Errorf("expected blue on the right, but got %v", c)
+	}
+	if c := fit.img.RGBAAt(1, 1); c.R == 0 || c.B == 0 {
+		t.Errorf("expected a mix in the middle, but got %v", c)
+	}
+}
+
+func TestImage_ContentType(t *testing.T) {
+	for format, expected := range map[string]string{"png": "image/png", "jpeg": "image/jpeg", "gif": "image/png"} {
+		img, _ := Decode(map[string][]byte{
+			"png":  encodePNG(halves(4, 4)),
+			"jpeg": encodeJPEG(halves(4, 4)),
+			"gif":  encodeGIF(halves(4, 4)),
+		}[format], 1000)
+
+		var buf bytes.Buffer
+		_ = img.Encode(&buf)
+
+		if img.ContentType() != expected {
+			t.Errorf("%s: expected %s, but got %s", format, expected, img.ContentType())
+		}
+		if _, encoded, _ := image.DecodeConfig(&buf); "image/"+encoded != expected {
+			t.Errorf("%s: expected it to be encoded as %s, but got %s", format, expected, encoded)
+		}
+	}
+}
+
+func TestParseVariants(t *testing.T) {
+	var tests = []struct {
+		name        string
+		list        string
+		expected    []Variant
+		expectError bool
+	}{
+		{"two", "thumbnail=64x64, medium=400x300", []Variant{{"thumbnail", 64, 64}, {"medium", 400, 300}}, false},
+		{"none", "", nil, false},
+		{"no size", "thumbnail", nil, true},
+		{"bad size", "thumbnail=64", nil, true},
+		{"zero size", "thumbnail=0x64", nil, true},
+		{"name with a path", "../x=64x64", nil, true},
+		{"twice", "thumbnail=64x64,thumbnail=32x32", nil, true},
+	}
+
+	for _, test := range tests {
+		variants, err := ParseVariants(test.list)
+		if (err != nil) != test.expectError {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+
+		if len(variants) != len(test.expected) {
+			t.Errorf("%s: expected %v, but got %v", test.name, test.expected, variants)
+			continue
+		}
+		for i := range variants {
+			if variants[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, but got %v", test.name, test.expected, variants)
+			}
+		}
+	}
+}
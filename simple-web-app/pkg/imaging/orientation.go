@@ -0,0 +1,123 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// orientationTag is the EXIF tag saying how a photo has to be turned to
+// show it the right way up.
+const orientationTag = 0x0112
+
+// jpegOrientation returns the EXIF orientation of a JPEG file, 1 to 8, or 1
+// if it has none. Cameras store photos the way the sensor saw them and set
+// the orientation instead of turning them, so without it, photos would show
+// sideways once their EXIF block is gone.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// the EXIF block is in an APP1 segment before the image data
+	for p := 2; p+4 <= len(data) && data[p] == 0xFF; {
+		marker := data[p+1]
+		length := int(binary.BigEndian.Uint16(data[p+2:]))
+		if marker == 0xDA || length < 2 || p+2+length > len(data) {
+			break
+		}
+
+		segment := data[p+4 : p+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+
+		p += 2 + length
+	}
+
+	return 1
+}
+
+// tiffOrientation finds the orientation tag in the first directory of the
+// TIFF structure of an EXIF block.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	dir := int(order.Uint32(tiff[4:]))
+	if dir < 8 || dir+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[dir:]))
+	for i := 0; i < entries; i++ {
+		entry := dir + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		// a SHORT, which is stored in the entry itself
+		if order.Uint16(tiff[entry:]) == orientationTag && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+		}
+	}
+
+	return 1
+}
+
+// orient turns and mirrors src as EXIF orientation o says, so that it is the
+// right way up.
+func orient(src *image.RGBA, o int) *image.RGBA {
+	if o <= 1 || o > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// source returns the pixel of src that ends up at x, y
+	var source func(x, y int) (int, int)
+	switch o {
+	case 2: // mirrored
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // upside down
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // upside down and mirrored
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // on its side and mirrored
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // turned left, to be turned right
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // on its other side and mirrored
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // turned right, to be turned left
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	bounds := image.Rect(0, 0, w, h)
+	if o >= 5 {
+		bounds = image.Rect(0, 0, h, w)
+	}
+
+	dst := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
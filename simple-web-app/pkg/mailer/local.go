@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogMailer writes every message to a logger instead of sending it. A nil
+// Logger uses the standard logger.
+type LogMailer struct {
+	From   string
+	Logger *log.Logger
+}
+
+// Send logs msg.
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	logger := m.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf("mail to %s\n%s", msg.To, format(m.From, msg, time.Now()))
+	return nil
+}
+
+// FileMailer writes every message to its own .eml file in Dir, which can be
+// opened with any mail client.
+type FileMailer struct {
+	From string
+	Dir  string
+
+	mu    sync.Mutex
+	count int
+}
+
+// Send writes msg to a new file.
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	m.count++
+	name := fmt.Sprintf("%s-%04d.eml", now.Format("20060102T150405"), m.count)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg, now), 0o644)
+}
@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	To:      "jack@example.com",
+	Subject: "Reset your password",
+	Body:    "Hello Jack,\nfollow the link.",
+}
+
+func Test_format(t *testing.T) {
+	now := time.Date(2022, 8, 19, 12, 0, 0, 0, time.UTC)
+	out := string(format("no-reply@example.com", testMessage, now))
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"from", "From: no-reply@example.com\r\n"},
+		{"to", "To: jack@example.com\r\n"},
+		{"subject", "Subject: Reset your password\r\n"},
+		{"date", "Date: Fri, 19 Aug 2022 12:00:00 +0000\r\n"},
+		{"content type", "Content-Type: text/plain; charset=utf-8\r\n"},
+		{"body with crlf", "\r\n\r\nHello Jack,\r\nfollow the link."},
+	}
+
+	for _, test := range tests {
+		if !strings.Contains(out, test.expected) {
+			t.Errorf("%s: did not find %q in %q", test.name, test.expected, out)
+		}
+	}
+
+	out = string(format("no-reply@example.com", Message{Subject: "Grüße"}, now))
+	if !strings.Contains(out, "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n") {
+		t.Errorf("non ascii subject was not encoded: %q", out)
+	}
+}
+
+func TestLogMailer_Send(t *testing.T) {
+	var buf bytes.Buffer
+	m := &LogMailer{From: "no-reply@example.com", Logger: log.New(&buf, "", 0)}
+
+	if err := m.Send(context.Background(), testMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "follow the link.") {
+		t.Errorf("message was not logged: %s", buf.String())
+	}
+}
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := &FileMailer{From: "no-reply@example.com", Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(context.Background(), testMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(files))
+	}
+
+	contents, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(contents), "To: jack@example.com") {
+		t.Errorf("unexpected file contents: %s", contents)
+	}
+}
+
+// fakeSMTPServer accepts a single SMTP conversation and sends what it was
+// told on the returned channel.
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []string, 1)
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		var lines []string
+
+		_ = tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				break
+			}
+			lines = append(lines, line)
+
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "EHLO", "HELO":
+				_ = tp.PrintfLine("250 localhost")
+			case "DATA":
+				_ = tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotLines()
+				lines = append(lines, data...)
+				_ = tp.PrintfLine("250 queued")
+			case "QUIT":
+				_ = tp.PrintfLine("221 bye")
+				received <- lines
+				return
+			default:
+				_ = tp.PrintfLine("250 ok")
+			}
+		}
+		received <- lines
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := net.LookupPort("tcp", portStr)
+
+	m := &SMTPMailer{Host: host, Port: port, From: "no-reply@example.com"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.Send(ctx, testMessage); err != nil {
+		t.Fatal("send failed:", err)
+	}
+
+	conversation := strings.Join(<-received, "\n")
+
+	var tests = []string{
+		"MAIL FROM:<no-reply@example.com>",
+		"RCPT TO:<jack@example.com>",
+		"Subject: Reset your password",
+		"follow the link.",
+		"QUIT",
+	}
+
+	for _, expected := range tests {
+		if !strings.Contains(conversation, expected) {
+			t.Errorf("server did not receive %q in:\n%s", expected, conversation)
+		}
+	}
+}
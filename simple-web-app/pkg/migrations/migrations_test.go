@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	var tests = []struct {
+		name          string
+		files         fstest.MapFS
+		expectError   bool
+		expectedCount int
+	}{
+		{
+			"valid",
+			fstest.MapFS{
+				"0002_second.up.sql":   {Data: []byte("create table b (id int);")},
+				"0002_second.down.sql": {Data: []byte("drop table b;")},
+				"0001_first.up.sql":    {Data: []byte("create table a (id int);")},
+				"0001_first.down.sql":  {Data: []byte("drop table a;")},
+			},
+			false, 2,
+		},
+		{
+			"missing down",
+			fstest.MapFS{
+				"0001_first.up.sql": {Data: []byte("create table a (id int);")},
+			},
+			true, 0,
+		},
+		{
+			"bad file name",
+			fstest.MapFS{
+				"first.sql": {Data: []byte("create table a (id int);")},
+			},
+			true, 0,
+		},
+		{
+			"two names for one version",
+			fstest.MapFS{
+				"0001_first.up.sql":   {Data: []byte("create table a (id int);")},
+				"0001_other.down.sql": {Data: []byte("drop table a;")},
+			},
+			true, 0,
+		},
+	}
+
+	for _, test := range tests {
+		list, err := Load(test.files)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error, but did not get one", test.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+
+		if len(list) != test.expectedCount {
+			t.Errorf("%s: expected %d migrations, got %d", test.name, test.expectedCount, len(list))
+			continue
+		}
+
+		for i := 1; i < len(list); i++ {
+			if list[i-1].Version >= list[i].Version {
+				t.Errorf("%s: migrations not sorted by version", test.name)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal("embedded migrations do not load:", err)
+	}
+
+	if len(m.Migrations) == 0 {
+		t.Fatal("no embedded migrations found")
+	}
+
+	if m.Latest() != m.Migrations[len(m.Migrations)-1].Version {
+		t.Errorf("wrong latest version %d", m.Latest())
+	}
+}
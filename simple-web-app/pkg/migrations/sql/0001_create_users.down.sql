@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS public.user_images;
+DROP TABLE IF EXISTS public.users;
@@ -0,0 +1,11 @@
+CREATE TABLE public.refresh_tokens (
+    id character varying(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    family_id character varying(64) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    revoked boolean DEFAULT false NOT NULL,
+    replaced_by character varying(64) DEFAULT '' NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON public.refresh_tokens USING btree (family_id);
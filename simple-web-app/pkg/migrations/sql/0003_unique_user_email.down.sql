@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.users_email_lower_idx;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX users_email_lower_idx ON public.users USING btree (lower(email));
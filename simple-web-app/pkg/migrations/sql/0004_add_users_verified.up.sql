@@ -0,0 +1 @@
+ALTER TABLE public.users ADD COLUMN verified boolean DEFAULT false NOT NULL;
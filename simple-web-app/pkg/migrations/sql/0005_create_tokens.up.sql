@@ -0,0 +1,9 @@
+CREATE TABLE public.tokens (
+    hash bytea PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    scope character varying(32) NOT NULL,
+    expires_at timestamp without time zone NOT NULL,
+    created_at timestamp without time zone
+);
+
+CREATE INDEX tokens_user_id_scope_idx ON public.tokens USING btree (user_id, scope);
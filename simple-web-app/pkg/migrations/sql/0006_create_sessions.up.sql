@@ -0,0 +1,7 @@
+CREATE TABLE public.sessions (
+    token character varying(64) PRIMARY KEY,
+    data bytea NOT NULL,
+    expiry timestamp without time zone NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON public.sessions USING btree (expiry);
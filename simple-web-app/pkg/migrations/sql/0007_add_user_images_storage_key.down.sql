@@ -0,0 +1 @@
+ALTER TABLE public.user_images DROP COLUMN IF EXISTS storage_key;
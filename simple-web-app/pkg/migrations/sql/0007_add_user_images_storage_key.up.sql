@@ -0,0 +1,3 @@
+ALTER TABLE public.user_images ADD COLUMN storage_key character varying(255) DEFAULT '' NOT NULL;
+-- images uploaded so far were stored in the image directory under their file name
+UPDATE public.user_images SET storage_key = file_name WHERE file_name IS NOT NULL;
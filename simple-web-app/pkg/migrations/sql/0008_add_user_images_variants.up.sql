@@ -0,0 +1 @@
+ALTER TABLE public.user_images ADD COLUMN variants jsonb DEFAULT '[]'::jsonb NOT NULL;
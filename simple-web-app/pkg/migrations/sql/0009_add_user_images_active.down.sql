@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.user_images_user_id_idx;
+DROP INDEX IF EXISTS public.user_images_active_idx;
+-- without the flag, users can only have one image again
+DELETE FROM public.user_images WHERE NOT active;
+ALTER TABLE public.user_images DROP COLUMN IF EXISTS active;
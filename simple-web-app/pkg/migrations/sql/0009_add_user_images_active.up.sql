@@ -0,0 +1,5 @@
+ALTER TABLE public.user_images ADD COLUMN active boolean DEFAULT false NOT NULL;
+-- until now every user had one image at most, their profile picture
+UPDATE public.user_images SET active = true;
+CREATE UNIQUE INDEX user_images_active_idx ON public.user_images USING btree (user_id) WHERE active;
+CREATE INDEX user_images_user_id_idx ON public.user_images USING btree (user_id);
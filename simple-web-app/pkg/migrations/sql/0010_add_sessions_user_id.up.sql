@@ -0,0 +1,4 @@
+-- the sessions of a user are looked up by this column rather than by decoding
+-- every session; sessions saved before it are filled in when next committed
+ALTER TABLE public.sessions ADD COLUMN user_id integer;
+CREATE INDEX sessions_user_id_idx ON public.sessions USING btree (user_id);
@@ -0,0 +1,110 @@
+package dbrepo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+// MemorySessionStore keeps the sessions of the web app in memory, like the
+// memstore of github.com/alexedwards/scs/v2, and indexes them by the user
+// logged in with them, as PostgresSessionStore does.
+type MemorySessionStore struct {
+	*memstore.MemStore
+	// UserID returns the ID of the user logged in with the session encoded in
+	// b, or 0 for none. Without it no session belongs to a user.
+	UserID func(b []byte) int
+
+	mu     sync.Mutex
+	tokens map[int]map[string]bool
+	users  map[string]int
+}
+
+// NewMemorySessionStore returns an empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		MemStore: memstore.New(),
+		tokens:   make(map[int]map[string]bool),
+		users:    make(map[string]int),
+	}
+}
+
+// Commit saves the data of a session, replacing what was stored before, and
+// notes which user it belongs to.
+func (m *MemorySessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := m.MemStore.Commit(token, b, expiry)
+	if err != nil {
+		return err
+	}
+
+	userID := 0
+	if m.UserID != nil {
+		userID = m.UserID(b)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.unindex(token)
+	if userID != 0 {
+		if m.tokens[userID] == nil {
+			m.tokens[userID] = make(map[string]bool)
+		}
+		m.tokens[userID][token] = true
+		m.users[token] = userID
+	}
+
+	return nil
+}
+
+// Delete removes a session. Unknown tokens are not an error.
+func (m *MemorySessionStore) Delete(token string) error {
+	err := m.MemStore.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.unindex(token)
+	return nil
+}
+
+// UserTokens returns the tokens of the unexpired sessions userID is logged in
+// with. Sessions that expired meanwhile are dropped from the index.
+func (m *MemorySessionStore) UserTokens(ctx context.Context, userID int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []string
+	for token := range m.tokens[userID] {
+		if _, found, _ := m.MemStore.Find(token); !found {
+			m.unindex(token)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+// unindex forgets the user of token. The caller holds m.mu.
+func (m *MemorySessionStore) unindex(token string) {
+	userID, ok := m.users[token]
+	if !ok {
+		return
+	}
+
+	delete(m.users, token)
+	delete(m.tokens[userID], token)
+	if len(m.tokens[userID]) == 0 {
+		delete(m.tokens, userID)
+	}
+}
@@ -0,0 +1,76 @@
+package dbrepo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore_UserTokens(t *testing.T) {
+	// the test sessions hold the user id as their data
+	store := NewMemorySessionStore()
+	defer store.StopCleanup()
+	store.UserID = func(b []byte) int {
+		id, _ := strconv.Atoi(string(b))
+		return id
+	}
+	ctx := context.Background()
+
+	sessions := []struct {
+		token  string
+		data   string
+		expiry time.Time
+	}{
+		{"first", "1", time.Now().Add(time.Hour)},
+		{"second", "1", time.Now().Add(time.Hour)},
+		{"expired", "1", time.Now().Add(-time.Minute)},
+		{"other", "2", time.Now().Add(time.Hour)},
+		{"anonymous", "", time.Now().Add(time.Hour)},
+		// logging out of a session takes it away from the user
+		{"logged out", "1", time.Now().Add(time.Hour)},
+		{"logged out", "", time.Now().Add(time.Hour)},
+		// and logging in as someone else moves it
+		{"switched", "1", time.Now().Add(time.Hour)},
+		{"switched", "3", time.Now().Add(time.Hour)},
+		{"deleted", "3", time.Now().Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		if err := store.Commit(s.token, []byte(s.data), s.expiry); err != nil {
+			t.Fatal("committing session failed:", err)
+		}
+	}
+
+	if err := store.Delete("deleted"); err != nil {
+		t.Fatal("deleting session failed:", err)
+	}
+
+	var tests = []struct {
+		name     string
+		userID   int
+		expected []string
+	}{
+		{"several sessions", 1, []string{"first", "second"}},
+		{"one session", 2, []string{"other"}},
+		{"switched user", 3, []string{"switched"}},
+		{"no sessions", 4, nil},
+	}
+
+	for _, test := range tests {
+		tokens, err := store.UserTokens(ctx, test.userID)
+		if err != nil {
+			t.Errorf("%s: listing tokens failed: %s", test.name, err)
+		}
+		sort.Strings(tokens)
+		if !reflect.DeepEqual(tokens, test.expected) {
+			t.Errorf("%s: expected %v but got %v", test.name, test.expected, tokens)
+		}
+	}
+
+	// the expired session was dropped from the index while listing
+	if _, ok := store.users["expired"]; ok {
+		t.Error("the expired session is still indexed")
+	}
+}
@@ -0,0 +1,181 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// PostgresSessionStore keeps the sessions of the web app in the sessions
+// table, so they survive restarts and are shared between instances. It
+// implements the store interfaces of github.com/alexedwards/scs/v2, including
+// iteration.
+type PostgresSessionStore struct {
+	DB *sql.DB
+	// UserID returns the ID of the user logged in with the session encoded in
+	// b, or 0 for none. The ID is saved alongside the session, so that
+	// UserTokens can find it; without UserID no session belongs to a user.
+	UserID      func(b []byte) int
+	stopCleanup chan bool
+}
+
+// NewPostgresSessionStore returns a session store on db that deletes expired
+// sessions every cleanupInterval. A cleanupInterval of 0 disables the cleanup,
+// expired sessions are ignored either way.
+func NewPostgresSessionStore(db *sql.DB, cleanupInterval time.Duration) *PostgresSessionStore {
+	s := &PostgresSessionStore{DB: db}
+	if cleanupInterval > 0 {
+		s.stopCleanup = make(chan bool)
+		go s.startCleanup(cleanupInterval)
+	}
+	return s
+}
+
+// Find returns the data of the unexpired session with the given token.
+func (s *PostgresSessionStore) Find(token string) ([]byte, bool, error) {
+	return s.FindCtx(context.Background(), token)
+}
+
+// FindCtx is Find, with a context.
+func (s *PostgresSessionStore) FindCtx(ctx context.Context, token string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select data from sessions where token = $1 and expiry > $2`
+
+	var b []byte
+	err := s.DB.QueryRowContext(ctx, query, token, time.Now().UTC()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+// Commit saves the data of a session, replacing what was stored before.
+func (s *PostgresSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	return s.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// CommitCtx is Commit, with a context.
+func (s *PostgresSessionStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if s.UserID != nil {
+		if id := s.UserID(b); id != 0 {
+			userID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+	}
+
+	stmt := `insert into sessions (token, data, expiry, user_id) values ($1, $2, $3, $4)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry, user_id = excluded.user_id`
+
+	_, err := s.DB.ExecContext(ctx, stmt, token, b, expiry.UTC(), userID)
+	return err
+}
+
+// UserTokens returns the tokens of the unexpired sessions userID is logged in
+// with.
+func (s *PostgresSessionStore) UserTokens(ctx context.Context, userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `select token from sessions where user_id = $1 and expiry > $2`, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Delete removes a session. Unknown tokens are not an error.
+func (s *PostgresSessionStore) Delete(token string) error {
+	return s.DeleteCtx(context.Background(), token)
+}
+
+// DeleteCtx is Delete, with a context.
+func (s *PostgresSessionStore) DeleteCtx(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from sessions where token = $1`, token)
+	return err
+}
+
+// All returns the data of every unexpired session, by token.
+func (s *PostgresSessionStore) All() (map[string][]byte, error) {
+	return s.AllCtx(context.Background())
+}
+
+// AllCtx is All, with a context.
+func (s *PostgresSessionStore) AllCtx(ctx context.Context) (map[string][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	rows, err := s.DB.QueryContext(ctx, `select token, data from sessions where expiry > $1`, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var b []byte
+		if err := rows.Scan(&token, &b); err != nil {
+			return nil, err
+		}
+		sessions[token] = b
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteExpired removes the sessions that have expired.
+func (s *PostgresSessionStore) DeleteExpired(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, `delete from sessions where expiry <= $1`, time.Now().UTC())
+	return err
+}
+
+// StopCleanup stops the goroutine deleting expired sessions. It is safe to call
+// when there is none.
+func (s *PostgresSessionStore) StopCleanup() {
+	if s.stopCleanup != nil {
+		s.stopCleanup <- true
+	}
+}
+
+func (s *PostgresSessionStore) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.DeleteExpired(context.Background()); err != nil {
+				log.Println("deleting expired sessions:", err)
+			}
+		case <-s.stopCleanup:
+			return
+		}
+	}
+}
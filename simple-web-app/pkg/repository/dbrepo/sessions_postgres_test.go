@@ -0,0 +1,148 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPostgresSessionStore(t *testing.T) {
+	store := NewPostgresSessionStore(testDB, 0)
+	ctx := context.Background()
+
+	err := store.CommitCtx(ctx, "active", []byte("first"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal("committing session failed:", err)
+	}
+
+	// committing again replaces the data
+	err = store.CommitCtx(ctx, "active", []byte("second"), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal("committing session again failed:", err)
+	}
+
+	err = store.CommitCtx(ctx, "expired", []byte("old"), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal("committing expired session failed:", err)
+	}
+
+	var tests = []struct {
+		name          string
+		token         string
+		expectedFound bool
+		expectedData  string
+	}{
+		{"active", "active", true, "second"},
+		{"expired", "expired", false, ""},
+		{"unknown", "unknown", false, ""},
+	}
+
+	for _, test := range tests {
+		b, found, err := store.FindCtx(ctx, test.token)
+		if err != nil {
+			t.Errorf("%s: find failed: %s", test.name, err)
+		}
+		if found != test.expectedFound || string(b) != test.expectedData {
+			t.Errorf("%s: expected %t %q but got %t %q", test.name, test.expectedFound, test.expectedData, found, b)
+		}
+	}
+
+	all, err := store.AllCtx(ctx)
+	if err != nil {
+		t.Fatal("listing sessions failed:", err)
+	}
+	if len(all) != 1 || string(all["active"]) != "second" {
+		t.Errorf("expected only the active session, got %v", all)
+	}
+
+	err = store.DeleteExpired(ctx)
+	if err != nil {
+		t.Error("deleting expired sessions failed:", err)
+	}
+
+	var count int
+	_ = testDB.QueryRow("select count(*) from sessions").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected 1 session left, got %d", count)
+	}
+
+	err = store.DeleteCtx(ctx, "active")
+	if err != nil {
+		t.Error("deleting session failed:", err)
+	}
+
+	if _, found, _ := store.FindCtx(ctx, "active"); found {
+		t.Error("deleted session was found")
+	}
+
+	// deleting an unknown session is not an error
+	if err := store.DeleteCtx(ctx, "active"); err != nil {
+		t.Error("deleting unknown session failed:", err)
+	}
+}
+
+func TestPostgresSessionStore_UserTokens(t *testing.T) {
+	_, _ = testDB.Exec("delete from sessions")
+	defer func() { _, _ = testDB.Exec("delete from sessions") }()
+
+	// the test sessions hold the user id as their data
+	store := NewPostgresSessionStore(testDB, 0)
+	store.UserID = func(b []byte) int {
+		id, _ := strconv.Atoi(string(b))
+		return id
+	}
+	ctx := context.Background()
+
+	sessions := []struct {
+		token  string
+		data   string
+		expiry time.Time
+	}{
+		{"first", "1", time.Now().Add(time.Hour)},
+		{"second", "1", time.Now().Add(time.Hour)},
+		{"expired", "1", time.Now().Add(-time.Minute)},
+		{"other", "2", time.Now().Add(time.Hour)},
+		{"anonymous", "", time.Now().Add(time.Hour)},
+		// logging out of a session takes it away from the user
+		{"logged out", "1", time.Now().Add(time.Hour)},
+		{"logged out", "", time.Now().Add(time.Hour)},
+	}
+	for _, s := range sessions {
+		if err := store.CommitCtx(ctx, s.token, []byte(s.data), s.expiry); err != nil {
+			t.Fatal("committing session failed:", err)
+		}
+	}
+
+	var tests = []struct {
+		name     string
+		userID   int
+		expected []string
+	}{
+		{"several sessions", 1, []string{"first", "second"}},
+		{"one session", 2, []string{"other"}},
+		{"no sessions", 3, nil},
+	}
+
+	for _, test := range tests {
+		tokens, err := store.UserTokens(ctx, test.userID)
+		if err != nil {
+			t.Errorf("%s: listing tokens failed: %s", test.name, err)
+		}
+		sort.Strings(tokens)
+		if !reflect.DeepEqual(tokens, test.expected) {
+			t.Errorf("%s: expected %v but got %v", test.name, test.expected, tokens)
+		}
+	}
+
+	var anonymous sql.NullInt64
+	_ = testDB.QueryRow("select user_id from sessions where token = 'anonymous'").Scan(&anonymous)
+	if anonymous.Valid {
+		t.Errorf("expected no user for the anonymous session, got %d", anonymous.Int64)
+	}
+}
@@ -0,0 +1,681 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryDBRepo is a DatabaseRepo that keeps everything in memory. It follows
+// the same rules as PostgresDBRepo - unique emails, increasing ids, hashed
+// passwords, every image a user uploaded kept with at most one of them active
+// as their profile picture - so it can stand in for Postgres in tests and
+// demos. It is safe for concurrent use.
+type MemoryDBRepo struct {
+	mu            sync.RWMutex
+	users         map[int]data.User
+	images        map[int]data.UserImage
+	refreshTokens map[string]data.RefreshToken
+	tokens        map[string]data.Token
+	lastUserID    int
+	lastImageID   int
+}
+
+// NewMemoryDBRepo returns an empty in-memory repository.
+func NewMemoryDBRepo() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		users:         make(map[int]data.User),
+		images:        make(map[int]data.UserImage),
+		refreshTokens: make(map[string]data.RefreshToken),
+		tokens:        make(map[string]data.Token),
+	}
+}
+
+// FixtureUser is a user to seed the repository with. Password holds a plain
+// text password, which gets hashed; PasswordHash holds a bcrypt hash, which is
+// stored as is and avoids the cost of hashing.
+type FixtureUser struct {
+	data.User
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// Fixtures is the data a MemoryDBRepo can be seeded with.
+type Fixtures struct {
+	Users      []FixtureUser    `json:"users"`
+	UserImages []data.UserImage `json:"user_images"`
+}
+
+// Seed adds fixtures to the repository. Users and images without an id get
+// the next free one; explicit ids are kept.
+func (m *MemoryDBRepo) Seed(f Fixtures) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, fu := range f.Users {
+		u := fu.User
+		u.Password = fu.PasswordHash
+		if u.Password == "" {
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(fu.Password), 12)
+			if err != nil {
+				return err
+			}
+			u.Password = string(hashedPassword)
+		}
+
+		if m.emailTaken(u.Email, u.ID) {
+			return fmt.Errorf("%w: %s", repository.ErrDuplicateEmail, u.Email)
+		}
+
+		if u.ID == 0 {
+			m.lastUserID++
+			u.ID = m.lastUserID
+		} else if u.ID > m.lastUserID {
+			m.lastUserID = u.ID
+		}
+
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = time.Now()
+		}
+		if u.UpdatedAt.IsZero() {
+			u.UpdatedAt = u.CreatedAt
+		}
+		u.ProfilePic = data.UserImage{}
+
+		m.users[u.ID] = u
+	}
+
+	for _, i := range f.UserImages {
+		if _, ok := m.users[i.UserID]; !ok {
+			return fmt.Errorf("image %s: user %d: %w", i.FileName, i.UserID, repository.ErrNotFound)
+		}
+
+		if i.ID == 0 {
+			m.lastImageID++
+			i.ID = m.lastImageID
+		} else if i.ID > m.lastImageID {
+			m.lastImageID = i.ID
+		}
+
+		// like the images uploaded before there was a storage key
+		if i.StorageKey == "" {
+			i.StorageKey = i.FileName
+		}
+
+		m.images[i.ID] = i
+	}
+
+	// like the images uploaded before there was a history, the latest image
+	// of a user without an active one is their profile picture
+	for userID := range m.users {
+		if m.profilePic(userID).ID != 0 {
+			continue
+		}
+
+		var latest data.UserImage
+		for _, i := range m.images {
+			if i.UserID == userID && i.ID > latest.ID {
+				latest = i
+			}
+		}
+		if latest.ID != 0 {
+			latest.Active = true
+			m.images[latest.ID] = latest
+		}
+	}
+
+	return nil
+}
+
+// LoadFixtures seeds the repository from a JSON file in the format of Fixtures.
+func (m *MemoryDBRepo) LoadFixtures(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f Fixtures
+	if err := json.Unmarshal(contents, &f); err != nil {
+		return err
+	}
+
+	return m.Seed(f)
+}
+
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// AllUsers returns all users as a slice of *data.User
+func (m *MemoryDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := m.userList()
+	sort.SliceStable(users, func(i, j int) bool { return users[i].LastName < users[j].LastName })
+
+	return users, nil
+}
+
+// ListUsers returns one page of users matching filter, along with the total
+// number of matching users.
+func (m *MemoryDBRepo) ListUsers(ctx context.Context, filter repository.UserFilter) ([]*data.User, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	page, total := filter.Apply(m.userList())
+	return page, total, nil
+}
+
+// GetUser returns one user by id
+func (m *MemoryDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	u.ProfilePic = m.profilePic(u.ID)
+	return &u, nil
+}
+
+// GetUserByEmail returns one user by email address, ignoring case, as the
+// address is unique regardless of case
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			u.ProfilePic = m.profilePic(u.ID)
+			return &u, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// UpdateUser updates one user in the database. Changing the email address
+// marks it unverified, until the user confirms the new one.
+func (m *MemoryDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := repository.ValidateUser(u); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[u.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	if m.emailTaken(u.Email, u.ID) {
+		return repository.ErrDuplicateEmail
+	}
+
+	existing.Verified = existing.Verified && strings.EqualFold(existing.Email, u.Email)
+	existing.Email = u.Email
+	existing.FirstName = u.FirstName
+	existing.LastName = u.LastName
+	existing.IsAdmin = u.IsAdmin
+	existing.UpdatedAt = time.Now()
+	m.users[u.ID] = existing
+
+	return nil
+}
+
+// DeleteUser deletes one user from the database, by id, along with their
+// images and tokens
+func (m *MemoryDBRepo) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return repository.ErrNotFound
+	}
+
+	delete(m.users, id)
+	for imageID, i := range m.images {
+		if i.UserID == id {
+			delete(m.images, imageID)
+		}
+	}
+	for tokenID, t := range m.refreshTokens {
+		if t.UserID == id {
+			delete(m.refreshTokens, tokenID)
+		}
+	}
+	for hash, t := range m.tokens {
+		if t.UserID == id {
+			delete(m.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := repository.ValidateUser(user); err != nil {
+		return 0, err
+	}
+
+	// hash outside the lock; bcrypt is slow on purpose
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	m.lastUserID++
+	user.ID = m.lastUserID
+	user.Password = string(hashedPassword)
+	user.Verified = false
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = time.Now()
+	user.ProfilePic = data.UserImage{}
+	m.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+// ResetPassword is the method we will use to change a user's password.
+func (m *MemoryDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	u.Password = string(hashedPassword)
+	m.users[id] = u
+
+	return nil
+}
+
+// InsertUserImage inserts a user profile image into the database, as the
+// active one. The images the user had before are kept.
+func (m *MemoryDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[i.UserID]; !ok {
+		return 0, repository.ErrNotFound
+	}
+
+	m.deactivateImages(i.UserID)
+
+	m.lastImageID++
+	i.ID = m.lastImageID
+	i.Active = true
+	i.CreatedAt = time.Now()
+	i.UpdatedAt = time.Now()
+	// don't share the variants with the caller
+	i.Variants = append([]data.ImageVariant(nil), i.Variants...)
+	m.images[i.ID] = i
+
+	return i.ID, nil
+}
+
+// ListUserImages returns the images of a user, newest first.
+func (m *MemoryDBRepo) ListUserImages(ctx context.Context, userID int) ([]data.UserImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var images []data.UserImage
+	for _, i := range m.images {
+		if i.UserID == userID {
+			images = append(images, i)
+		}
+	}
+	sort.Slice(images, func(a, b int) bool { return images[a].ID > images[b].ID })
+
+	return images, nil
+}
+
+// ActivateUserImage makes one of the images of a user their profile picture.
+func (m *MemoryDBRepo) ActivateUserImage(ctx context.Context, userID, imageID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.images[imageID]
+	if !ok || i.UserID != userID {
+		return repository.ErrNotFound
+	}
+
+	if i.Active {
+		return nil
+	}
+
+	m.deactivateImages(userID)
+	i.Active = true
+	i.UpdatedAt = time.Now()
+	m.images[imageID] = i
+
+	return nil
+}
+
+// DeleteUserImage deletes one of the images of a user, and returns it. If it
+// was the active one, the user has no profile picture afterwards.
+func (m *MemoryDBRepo) DeleteUserImage(ctx context.Context, userID, imageID int) (*data.UserImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i, ok := m.images[imageID]
+	if !ok || i.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+
+	delete(m.images, imageID)
+	return &i, nil
+}
+
+// InsertRefreshToken stores a newly issued refresh token.
+func (m *MemoryDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return repository.ErrNotFound
+	}
+
+	if _, ok := m.refreshTokens[t.ID]; ok {
+		return repository.ErrConflict
+	}
+
+	t.Revoked = false
+	t.ReplacedBy = ""
+	t.CreatedAt = time.Now()
+	m.refreshTokens[t.ID] = t
+
+	return nil
+}
+
+// GetRefreshToken returns one refresh token by id
+func (m *MemoryDBRepo) GetRefreshToken(ctx context.Context, id string) (*data.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.refreshTokens[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	return &t, nil
+}
+
+// RevokeRefreshToken marks a refresh token as used, recording the id of the
+// token that replaces it, if any. It reports false if the token was already
+// revoked, which means it is being reused.
+func (m *MemoryDBRepo) RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[id]
+	if !ok || t.Revoked {
+		return false, nil
+	}
+
+	t.Revoked = true
+	t.ReplacedBy = replacedBy
+	m.refreshTokens[id] = t
+
+	return true, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descended from the same login.
+func (m *MemoryDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, t := range m.refreshTokens {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+			m.refreshTokens[id] = t
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, logging them
+// out of the api everywhere.
+func (m *MemoryDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, t := range m.refreshTokens {
+		if t.UserID == userID {
+			t.Revoked = true
+			m.refreshTokens[id] = t
+		}
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that a user has confirmed their email address.
+func (m *MemoryDBRepo) MarkEmailVerified(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	u.Verified = true
+	m.users[id] = u
+
+	return nil
+}
+
+// InsertToken stores the hash of a newly issued single use token.
+func (m *MemoryDBRepo) InsertToken(ctx context.Context, t data.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return repository.ErrNotFound
+	}
+
+	key := string(t.Hash)
+	if _, ok := m.tokens[key]; ok {
+		return repository.ErrConflict
+	}
+
+	t.Plaintext = ""
+	m.tokens[key] = t
+
+	return nil
+}
+
+// ConsumeToken deletes the unexpired token with the given scope and hash, and
+// returns the id of the user it was issued to. Unknown and expired tokens
+// return ErrNotFound.
+func (m *MemoryDBRepo) ConsumeToken(ctx context.Context, scope string, hash []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[string(hash)]
+	if !ok || t.Scope != scope || !t.ExpiresAt.After(time.Now()) {
+		return 0, repository.ErrNotFound
+	}
+
+	delete(m.tokens, string(hash))
+
+	return t.UserID, nil
+}
+
+// DeleteTokensForUser deletes every token of a user with the given scope.
+func (m *MemoryDBRepo) DeleteTokensForUser(ctx context.Context, scope string, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, t := range m.tokens {
+		if t.UserID == userID && t.Scope == scope {
+			delete(m.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+// userList returns copies of every user, ordered by id. The caller must hold m.mu.
+func (m *MemoryDBRepo) userList() []*data.User {
+	users := make([]*data.User, 0, len(m.users))
+	for _, u := range m.users {
+		u := u
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// profilePic returns the active image of a user, if any. The caller must hold
+// m.mu.
+func (m *MemoryDBRepo) profilePic(userID int) data.UserImage {
+	for _, i := range m.images {
+		if i.UserID == userID && i.Active {
+			return i
+		}
+	}
+	return data.UserImage{}
+}
+
+// deactivateImages leaves a user without an active image. The caller must
+// hold m.mu.
+func (m *MemoryDBRepo) deactivateImages(userID int) {
+	for id, i := range m.images {
+		if i.UserID == userID && i.Active {
+			i.Active = false
+			i.UpdatedAt = time.Now()
+			m.images[id] = i
+		}
+	}
+}
+
+// emailTaken reports whether a user other than exceptID uses email, ignoring
+// case. The caller must hold m.mu.
+func (m *MemoryDBRepo) emailTaken(email string, exceptID int) bool {
+	for _, u := range m.users {
+		if u.ID != exceptID && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"simple-web-app/pkg/repository/repotest"
+	"sync"
+	"testing"
+)
+
+func TestMemoryDBRepoLoadFixtures(t *testing.T) {
+	repo := NewMemoryDBRepo()
+	err := repo.LoadFixtures("./../../../fixtures/users.json")
+	if err != nil {
+		t.Fatal("loading fixtures failed:", err)
+	}
+
+	user, err := repo.GetUserByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal("seeded user not found:", err)
+	}
+
+	matches, _ := user.PasswordMatches("secret")
+	if !matches {
+		t.Error("seeded password hash was not stored as is")
+	}
+
+	// new users continue after the highest seeded id
+	id, err := repo.InsertUser(context.Background(), data.User{Email: "new@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 3 {
+		t.Errorf("expected id 3 after the fixtures, got %d", id)
+	}
+}
+
+func TestMemoryDBRepoSeed(t *testing.T) {
+	repo := NewMemoryDBRepo()
+
+	err := repo.Seed(Fixtures{
+		Users: []FixtureUser{
+			{User: data.User{FirstName: "Plain", Email: "plain@example.com"}, Password: "secret"},
+		},
+		UserImages: []data.UserImage{{UserID: 1, FileName: "plain.png"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := repo.GetUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matches, _ := user.PasswordMatches("secret"); !matches {
+		t.Error("plain text fixture password was not hashed")
+	}
+
+	if user.ProfilePic.FileName != "plain.png" || user.ProfilePic.StorageKey != "plain.png" {
+		t.Errorf("expected profile picture plain.png stored as plain.png, got %q at %q", user.ProfilePic.FileName, user.ProfilePic.StorageKey)
+	}
+
+	err = repo.Seed(Fixtures{Users: []FixtureUser{{User: data.User{Email: "PLAIN@example.com"}, PasswordHash: "x"}}})
+	if err == nil {
+		t.Error("seeding a duplicate email did not fail")
+	}
+
+	err = repo.Seed(Fixtures{UserImages: []data.UserImage{{UserID: 100, FileName: "orphan.png"}}})
+	if err == nil {
+		t.Error("seeding an image for an unknown user did not fail")
+	}
+}
+
+func TestMemoryDBRepoCancelledContext(t *testing.T) {
+	repo := NewMemoryDBRepo()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.AllUsers(ctx); err == nil {
+		t.Error("AllUsers ignored a cancelled context")
+	}
+
+	if _, err := repo.InsertUser(ctx, data.User{Email: "x@example.com"}); err == nil {
+		t.Error("InsertUser ignored a cancelled context")
+	}
+}
+
+func TestMemoryDBRepoConcurrentInserts(t *testing.T) {
+	repo := NewMemoryDBRepo()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := repo.InsertUser(context.Background(), data.User{Email: fmt.Sprintf("user%d@example.com", i)})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	users, _ := repo.AllUsers(context.Background())
+	if len(users) != 5 {
+		t.Fatalf("expected 5 users, got %d", len(users))
+	}
+
+	seen := make(map[int]bool)
+	for _, u := range users {
+		if seen[u.ID] {
+			t.Errorf("id %d handed out twice", u.ID)
+		}
+		seen[u.ID] = true
+	}
+}
+
+func TestMemoryDBRepoConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryDBRepo()
+	})
+}
@@ -3,15 +3,29 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dbTimeout caps every query. It is applied on top of the caller's context, so
+// whichever of the two expires first wins.
 const dbTimeout = time.Second * 3
 
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
 type PostgresDBRepo struct {
 	DB *sql.DB
 }
@@ -21,11 +35,11 @@ func (m *PostgresDBRepo) Connection() *sql.DB {
 }
 
 // AllUsers returns all users as a slice of *data.User
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at from users order by last_name`
+	query := `select id, email, first_name, last_name, password, is_admin, verified, created_at, updated_at from users order by last_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -44,6 +58,7 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.IsAdmin,
+			&user.Verified,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -58,22 +73,108 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 	return users, nil
 }
 
+// ListUsers returns one page of users matching filter, along with the total
+// number of matching users.
+func (m *PostgresDBRepo) ListUsers(ctx context.Context, filter repository.UserFilter) ([]*data.User, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	var where []string
+	var args []any
+
+	if filter.Email != "" {
+		args = append(args, filter.Email)
+		where = append(where, fmt.Sprintf("strpos(lower(email), lower($%d)) > 0", len(args)))
+	}
+	if filter.Name != "" {
+		args = append(args, filter.Name)
+		where = append(where, fmt.Sprintf("(strpos(lower(first_name), lower($%d)) > 0 or strpos(lower(last_name), lower($%d)) > 0)", len(args), len(args)))
+	}
+	if filter.IsAdmin != nil {
+		args = append(args, *filter.IsAdmin)
+		where = append(where, fmt.Sprintf("is_admin = $%d", len(args)))
+	}
+
+	conditions := ""
+	if len(where) > 0 {
+		conditions = "where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, "select count(*) from users "+conditions, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the sort column has been checked against repository.UserSortFields by Validate,
+	// so it is safe to use in the query
+	direction := "asc"
+	if filter.SortDescending() {
+		direction = "desc"
+	}
+
+	args = append(args, filter.Limit(), filter.Offset())
+	query := fmt.Sprintf(`select id, email, first_name, last_name, password, is_admin, verified, created_at, updated_at
+		from users %s order by %s %s, id asc limit $%d offset $%d`,
+		conditions, filter.SortColumn(), direction, len(args)-1, len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*data.User
+
+	for rows.Next() {
+		var user data.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.IsAdmin,
+			&user.Verified,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			log.Println("Error scanning", err)
+			return nil, 0, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
 // GetUser returns one user by id
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.verified, u.created_at, u.updated_at,
+			coalesce(ui.file_name, ''), coalesce(ui.storage_key, ''), coalesce(ui.variants, '[]')
 		from 
 			users u
-			left join user_images ui on (ui.user_id = u.id)
+			left join user_images ui on (ui.user_id = u.id and ui.active)
 		where 
 		    u.id = $1`
 
 	var user data.User
+	var variants []byte
 	row := m.DB.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
@@ -83,34 +184,44 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.Verified,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.ProfilePic.FileName,
+		&user.ProfilePic.StorageKey,
+		&variants,
 	)
 
 	if err != nil {
+		return nil, repoError(err)
+	}
+
+	if err := json.Unmarshal(variants, &user.ProfilePic.Variants); err != nil {
 		return nil, err
 	}
+	user.ProfilePic.Active = user.ProfilePic.StorageKey != ""
 
 	return &user, nil
 }
 
-// GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// GetUserByEmail returns one user by email address, ignoring case, as the
+// address is unique regardless of case
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.verified, u.created_at, u.updated_at,
+			coalesce(ui.file_name, ''), coalesce(ui.storage_key, ''), coalesce(ui.variants, '[]')
 		from 
 			users u
-			left join user_images ui on (ui.user_id = u.id)
+			left join user_images ui on (ui.user_id = u.id and ui.active)
 		where 
-		    u.email = $1`
+		    lower(u.email) = lower($1)`
 
 	var user data.User
+	var variants []byte
 	row := m.DB.QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
@@ -120,33 +231,47 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.Verified,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.ProfilePic.FileName,
+		&user.ProfilePic.StorageKey,
+		&variants,
 	)
 
 	if err != nil {
+		return nil, repoError(err)
+	}
+
+	if err := json.Unmarshal(variants, &user.ProfilePic.Variants); err != nil {
 		return nil, err
 	}
+	user.ProfilePic.Active = user.ProfilePic.StorageKey != ""
 
 	return &user, nil
 }
 
-// UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateUser updates one user in the database. Changing the email address
+// marks it unverified, until the user confirms the new one.
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
+	if err := repository.ValidateUser(u); err != nil {
+		return err
+	}
+
 	stmt := `update users set
 		email = $1,
 		first_name = $2,
 		last_name = $3,
 		is_admin = $4,
-		updated_at = $5
+		updated_at = $5,
+		verified = verified and lower(email) = lower($1)
 		where id = $6
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	result, err := m.DB.ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
@@ -155,33 +280,55 @@ func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 		u.ID,
 	)
 
+	if err != nil {
+		return repoError(err)
+	}
+
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+
 	return nil
 }
 
 // DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+
 	return nil
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
+	if err := repository.ValidateUser(user); err != nil {
+		return 0, err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
 	if err != nil {
 		return 0, err
@@ -202,15 +349,15 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, repoError(err)
 	}
 
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
@@ -219,39 +366,385 @@ func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 	}
 
 	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	result, err := m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+
 	return nil
 }
 
-// InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// InsertUserImage inserts a user profile image into the database, as the
+// active one. The images the user had before are kept.
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// an index makes sure only one image is active, so the old one has to go
+	// first
+	if err := deactivateUserImages(ctx, tx, i.UserID); err != nil {
+		return 0, err
+	}
+
+	variants := i.Variants
+	if variants == nil {
+		variants = []data.ImageVariant{}
+	}
+	encodedVariants, err := json.Marshal(variants)
 	if err != nil {
 		return 0, err
 	}
 
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	stmt := `insert into user_images (user_id, file_name, storage_key, variants, active, created_at, updated_at)
+		values ($1, $2, $3, $4, true, $5, $6) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		i.UserID,
 		i.FileName,
+		i.StorageKey,
+		string(encodedVariants),
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
+		return 0, repoError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return 0, err
 	}
 
 	return newID, nil
 }
+
+// ListUserImages returns the images of a user, newest first.
+func (m *PostgresDBRepo) ListUserImages(ctx context.Context, userID int) ([]data.UserImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select ` + userImageColumns + ` from user_images where user_id = $1 order by id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []data.UserImage
+	for rows.Next() {
+		i, err := scanUserImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *i)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// ActivateUserImage makes one of the images of a user their profile picture.
+func (m *PostgresDBRepo) ActivateUserImage(ctx context.Context, userID, imageID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var active bool
+	err = tx.QueryRowContext(ctx, `select active from user_images where id = $1 and user_id = $2 for update`, imageID, userID).Scan(&active)
+	if err != nil {
+		return repoError(err)
+	}
+
+	if active {
+		return nil
+	}
+
+	if err := deactivateUserImages(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update user_images set active = true, updated_at = $2 where id = $1`, imageID, time.Now())
+	if err != nil {
+		return repoError(err)
+	}
+
+	return tx.Commit()
+}
+
+// DeleteUserImage deletes one of the images of a user, and returns it. If it
+// was the active one, the user has no profile picture afterwards.
+func (m *PostgresDBRepo) DeleteUserImage(ctx context.Context, userID, imageID int) (*data.UserImage, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from user_images where id = $1 and user_id = $2 returning ` + userImageColumns
+
+	i, err := scanUserImage(m.DB.QueryRowContext(ctx, stmt, imageID, userID))
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	return i, nil
+}
+
+// userImageColumns are the columns scanUserImage reads.
+const userImageColumns = `id, user_id, coalesce(file_name, ''), storage_key, variants, active, created_at, updated_at`
+
+// scanUserImage reads the userImageColumns of a row.
+func scanUserImage(row interface{ Scan(dest ...any) error }) (*data.UserImage, error) {
+	var i data.UserImage
+	var variants []byte
+
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.FileName,
+		&i.StorageKey,
+		&variants,
+		&i.Active,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(variants, &i.Variants); err != nil {
+		return nil, err
+	}
+
+	return &i, nil
+}
+
+// deactivateUserImages leaves a user without an active image, until the
+// transaction activates another one.
+func deactivateUserImages(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, `update user_images set active = false, updated_at = $2 where user_id = $1 and active`, userID, time.Now())
+	return err
+}
+
+// InsertRefreshToken stores a newly issued refresh token.
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, t data.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, user_id, family_id, expires_at, revoked, replaced_by, created_at)
+		values ($1, $2, $3, $4, false, '', $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		t.ID,
+		t.UserID,
+		t.FamilyID,
+		t.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return repoError(err)
+	}
+
+	return nil
+}
+
+// GetRefreshToken returns one refresh token by id
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, id string) (*data.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, family_id, expires_at, revoked, replaced_by, created_at
+		from refresh_tokens where id = $1`
+
+	var t data.RefreshToken
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.ExpiresAt,
+		&t.Revoked,
+		&t.ReplacedBy,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, repoError(err)
+	}
+
+	return &t, nil
+}
+
+// RevokeRefreshToken marks a refresh token as used, recording the id of the
+// token that replaces it, if any. It reports false if the token was already
+// revoked, which means it is being reused.
+func (m *PostgresDBRepo) RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true, replaced_by = $1 where id = $2 and not revoked`
+	result, err := m.DB.ExecContext(ctx, stmt, replacedBy, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descended from the same login.
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true where family_id = $1 and not revoked`
+	_, err := m.DB.ExecContext(ctx, stmt, familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user, logging them
+// out of the api everywhere.
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked = true where user_id = $1 and not revoked`
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkEmailVerified records that a user has confirmed their email address.
+func (m *PostgresDBRepo) MarkEmailVerified(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set verified = true where id = $1`
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// InsertToken stores the hash of a newly issued single use token.
+func (m *PostgresDBRepo) InsertToken(ctx context.Context, t data.Token) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into tokens (hash, user_id, scope, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		t.Hash,
+		t.UserID,
+		t.Scope,
+		t.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return repoError(err)
+	}
+
+	return nil
+}
+
+// ConsumeToken deletes the unexpired token with the given scope and hash, and
+// returns the id of the user it was issued to. Unknown and expired tokens
+// return ErrNotFound.
+func (m *PostgresDBRepo) ConsumeToken(ctx context.Context, scope string, hash []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	// deleting and returning in one statement makes sure only one caller
+	// gets to use the token
+	stmt := `delete from tokens where hash = $1 and scope = $2 and expires_at > $3 returning user_id`
+
+	var userID int
+	err := m.DB.QueryRowContext(ctx, stmt, hash, scope, time.Now()).Scan(&userID)
+	if err != nil {
+		return 0, repoError(err)
+	}
+
+	return userID, nil
+}
+
+// DeleteTokensForUser deletes every token of a user with the given scope.
+func (m *PostgresDBRepo) DeleteTokensForUser(ctx context.Context, scope string, userID int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from tokens where user_id = $1 and scope = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, scope)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// repoError translates the errors of database/sql and Postgres into the errors
+// of the repository package. Errors it does not know are returned as is.
+func repoError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case uniqueViolation:
+			if pgErr.ConstraintName == "users_email_lower_idx" {
+				return repository.ErrDuplicateEmail
+			}
+			return repository.ErrConflict
+		case foreignKeyViolation:
+			// deletes cascade, so only an insert referring to a row that
+			// does not exist gets here
+			return repository.ErrNotFound
+		}
+	}
+
+	return err
+}
@@ -3,12 +3,15 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/migrations"
 	"simple-web-app/pkg/repository"
+	"simple-web-app/pkg/repository/repotest"
 	"testing"
 	"time"
 
@@ -32,6 +35,7 @@ var resource *dockertest.Resource
 var pool *dockertest.Pool
 var testDB *sql.DB
 var testRepo repository.DatabaseRepo
+var migrator *migrations.Migrator
 
 func TestMain(m *testing.M) {
 	// connect to docker; fail if docker not running
@@ -81,7 +85,12 @@ func TestMain(m *testing.M) {
 	}
 
 	// populate the database with empty tables
-	err = createTables()
+	migrator, err = migrations.New(testDB)
+	if err != nil {
+		log.Fatalf("error loading migrations: %s", err)
+	}
+
+	err = migrator.Up(context.Background())
 	if err != nil {
 		log.Fatalf("error creating tables: %s", err)
 	}
@@ -99,26 +108,49 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func createTables() error {
-	tableSQL, err := os.ReadFile("./testdata/users.sql")
+func Test_pingDB(t *testing.T) {
+	err := testDB.Ping()
 	if err != nil {
-		fmt.Println(err)
-		return err
+		t.Error("can't ping database")
 	}
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
 
-	_, err = testDB.Exec(string(tableSQL))
+	status, err := migrator.Status(ctx)
 	if err != nil {
-		fmt.Println(err)
-		return err
+		t.Fatal("migration status reports an error:", err)
 	}
 
-	return nil
-}
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("migration %d_%s was not applied", s.Version, s.Name)
+		}
+	}
 
-func Test_pingDB(t *testing.T) {
-	err := testDB.Ping()
+	// roll everything back, and apply it again
+	err = migrator.To(ctx, 0)
 	if err != nil {
-		t.Error("can't ping database")
+		t.Fatal("rolling back all migrations failed:", err)
+	}
+
+	var exists bool
+	_ = testDB.QueryRow("select exists (select 1 from information_schema.tables where table_name = 'users')").Scan(&exists)
+	if exists {
+		t.Error("users table still exists after rolling back all migrations")
+	}
+
+	err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatal("re-applying migrations failed:", err)
+	}
+
+	status, _ = migrator.Status(ctx)
+	for _, s := range status {
+		if !s.Applied {
+			t.Errorf("migration %d_%s was not re-applied", s.Version, s.Name)
+		}
 	}
 }
 
@@ -133,7 +165,7 @@ func TestPostgresDBRepoInsertUser(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	id, err := testRepo.InsertUser(testUser)
+	id, err := testRepo.InsertUser(context.Background(), testUser)
 	if err != nil {
 		t.Errorf("insert user returned an error: %s", err)
 	}
@@ -144,7 +176,7 @@ func TestPostgresDBRepoInsertUser(t *testing.T) {
 }
 
 func TestPostgresDBRepoAllUsers(t *testing.T) {
-	users, err := testRepo.AllUsers()
+	users, err := testRepo.AllUsers(context.Background())
 	if err != nil {
 		t.Errorf("all users reports an error: %s", err)
 	}
@@ -162,9 +194,9 @@ func TestPostgresDBRepoAllUsers(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	_, _ = testRepo.InsertUser(testUser)
+	_, _ = testRepo.InsertUser(context.Background(), testUser)
 
-	users, err = testRepo.AllUsers()
+	users, err = testRepo.AllUsers(context.Background())
 	if err != nil {
 		t.Errorf("all users reports an error: %s", err)
 	}
@@ -174,8 +206,37 @@ func TestPostgresDBRepoAllUsers(t *testing.T) {
 	}
 }
 
+func TestPostgresDBRepoListUsers(t *testing.T) {
+	users, total, err := testRepo.ListUsers(context.Background(), repository.UserFilter{Sort: "-id", PerPage: 1})
+	if err != nil {
+		t.Errorf("list users reports an error: %s", err)
+	}
+
+	if total != 2 {
+		t.Errorf("list users reports wrong total; expected 2, but got %d", total)
+	}
+
+	if len(users) != 1 || users[0].ID != 2 {
+		t.Errorf("list users returned the wrong page: %v", users)
+	}
+
+	users, total, err = testRepo.ListUsers(context.Background(), repository.UserFilter{Name: "SMI"})
+	if err != nil {
+		t.Errorf("list users reports an error: %s", err)
+	}
+
+	if total != 1 || len(users) != 1 || users[0].Email != "jack@smith.com" {
+		t.Errorf("list users filtered by name returned the wrong users: %v", users)
+	}
+
+	_, _, err = testRepo.ListUsers(context.Background(), repository.UserFilter{Sort: "password"})
+	if err == nil {
+		t.Error("list users accepted an invalid sort field")
+	}
+}
+
 func TestPostgresDBRepoGetUser(t *testing.T) {
-	user, err := testRepo.GetUser(1)
+	user, err := testRepo.GetUser(context.Background(), 1)
 	if err != nil {
 		t.Errorf("error getting user by id: %s", err)
 	}
@@ -184,14 +245,14 @@ func TestPostgresDBRepoGetUser(t *testing.T) {
 		t.Errorf("wrong email returned: expected admin@example.com but got %s", user.Email)
 	}
 
-	_, err = testRepo.GetUser(3)
+	_, err = testRepo.GetUser(context.Background(), 3)
 	if err == nil {
 		t.Error("no error reported when getting non existent user by id")
 	}
 }
 
 func TestPostgresDBRepoGetUserByEmail(t *testing.T) {
-	user, err := testRepo.GetUserByEmail("jack@smith.com")
+	user, err := testRepo.GetUserByEmail(context.Background(), "jack@smith.com")
 	if err != nil {
 		t.Errorf("error getting user by email: %s", err)
 	}
@@ -202,40 +263,40 @@ func TestPostgresDBRepoGetUserByEmail(t *testing.T) {
 }
 
 func TestPostgresDBRepoUpdateUser(t *testing.T) {
-	user, _ := testRepo.GetUser(2)
+	user, _ := testRepo.GetUser(context.Background(), 2)
 	user.FirstName = "Jane"
 	user.Email = "jane@smith.com"
 
-	err := testRepo.UpdateUser(*user)
+	err := testRepo.UpdateUser(context.Background(), *user)
 	if err != nil {
 		t.Errorf("error updating user %d: %s", 2, err)
 	}
 
-	user, _ = testRepo.GetUser(2)
+	user, _ = testRepo.GetUser(context.Background(), 2)
 	if user.FirstName != "Jane" || user.Email != "jane@smith.com" {
 		t.Errorf("expected updated record to have first name Jane and email jane@smith.com but got %s %s", user.FirstName, user.Email)
 	}
 }
 
 func TestPostgresDBRepoDeleteUser(t *testing.T) {
-	err := testRepo.DeleteUser(2)
+	err := testRepo.DeleteUser(context.Background(), 2)
 	if err != nil {
 		t.Errorf("error deleting user %d: %s", 2, err)
 	}
 
-	_, err = testRepo.GetUser(2)
+	_, err = testRepo.GetUser(context.Background(), 2)
 	if err == nil {
 		t.Error("retreived user id 2, who should have been deleted")
 	}
 }
 
 func TestPostgresDBRepoResetPassword(t *testing.T) {
-	err := testRepo.ResetPassword(1, "password")
+	err := testRepo.ResetPassword(context.Background(), 1, "password")
 	if err != nil {
 		t.Errorf("error resetting password of user %d: %s", 2, err)
 	}
 
-	user, _ := testRepo.GetUser(1)
+	user, _ := testRepo.GetUser(context.Background(), 1)
 	matches, err := user.PasswordMatches("password")
 	if err != nil {
 		t.Error(err)
@@ -248,13 +309,14 @@ func TestPostgresDBRepoResetPassword(t *testing.T) {
 
 func TestPostgresDBRepoInsertUserImage(t *testing.T) {
 	image := data.UserImage{
-		ID:        1,
-		UserID:    1,
-		FileName:  "test.jpg",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-	newID, err := testRepo.InsertUserImage(image)
+		ID:         1,
+		UserID:     1,
+		FileName:   "test.jpg",
+		StorageKey: "test.jpg",
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	newID, err := testRepo.InsertUserImage(context.Background(), image)
 	if err != nil {
 		t.Error("inserting user image failed:", err)
 		return
@@ -265,8 +327,75 @@ func TestPostgresDBRepoInsertUserImage(t *testing.T) {
 	}
 
 	image.UserID = 100
-	_, err = testRepo.InsertUserImage(image)
+	_, err = testRepo.InsertUserImage(context.Background(), image)
 	if err == nil {
 		t.Error("inserted user image with non existing user id: ", err)
 	}
 }
+
+func TestPostgresDBRepoRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	token := data.RefreshToken{
+		ID:        "first",
+		UserID:    1,
+		FamilyID:  "first",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	err := testRepo.InsertRefreshToken(ctx, token)
+	if err != nil {
+		t.Fatal("inserting refresh token failed:", err)
+	}
+
+	token.ID = "second"
+	err = testRepo.InsertRefreshToken(ctx, token)
+	if err != nil {
+		t.Fatal("inserting refresh token failed:", err)
+	}
+
+	ok, err := testRepo.RevokeRefreshToken(ctx, "first", "second")
+	if err != nil || !ok {
+		t.Errorf("revoking refresh token failed: %t %v", ok, err)
+	}
+
+	ok, _ = testRepo.RevokeRefreshToken(ctx, "first", "second")
+	if ok {
+		t.Error("revoking a revoked refresh token reported success")
+	}
+
+	stored, err := testRepo.GetRefreshToken(ctx, "first")
+	if err != nil {
+		t.Fatal("getting refresh token failed:", err)
+	}
+
+	if !stored.Revoked || stored.ReplacedBy != "second" {
+		t.Errorf("expected revoked token replaced by second, got %+v", stored)
+	}
+
+	err = testRepo.RevokeRefreshTokenFamily(ctx, "first")
+	if err != nil {
+		t.Error("revoking refresh token family failed:", err)
+	}
+
+	stored, _ = testRepo.GetRefreshToken(ctx, "second")
+	if !stored.Revoked {
+		t.Error("refresh token family was not revoked")
+	}
+
+	_, err = testRepo.GetRefreshToken(ctx, "missing")
+	if err == nil {
+		t.Error("no error reported when getting non existent refresh token")
+	}
+}
+
+// TestPostgresDBRepoConformance runs last, as it empties the tables before
+// every sub test.
+func TestPostgresDBRepoConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		_, err := testDB.Exec("truncate users, user_images, refresh_tokens, tokens restart identity cascade")
+		if err != nil {
+			t.Fatal("emptying tables failed:", err)
+		}
+		return testRepo
+	})
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"errors"
+	"strings"
+
+	"simple-web-app/pkg/data"
+)
+
+// The errors every DatabaseRepo implementation returns, so that callers can
+// tell what went wrong with errors.Is, whatever the store behind it.
+var (
+	// ErrNotFound means the record asked for, or one it refers to, does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrDuplicateEmail means another user already has the email address,
+	// compared ignoring case.
+	ErrDuplicateEmail = errors.New("email address already in use")
+	// ErrConflict means the change clashes with a record that already exists.
+	ErrConflict = errors.New("conflicting record")
+	// ErrValidation is wrapped by every ValidationError.
+	ErrValidation = errors.New("invalid input")
+)
+
+// ValidationError reports a value that a store refuses to accept. It matches
+// ErrValidation with errors.Is.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// ValidateUser checks the fields of a user a store needs before inserting or
+// updating it.
+func ValidateUser(u data.User) error {
+	if strings.TrimSpace(u.Email) == "" {
+		return &ValidationError{Field: "email", Message: "must not be empty"}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"simple-web-app/pkg/data"
+)
+
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+// UserSortFields lists the fields a user listing may be sorted by.
+var UserSortFields = []string{"id", "email", "first_name", "last_name", "is_admin", "created_at"}
+
+// UserFilter describes which users a listing returns, in what order, and which
+// page of the result is wanted. The zero value lists every user, ordered by
+// last name, using the default page size.
+type UserFilter struct {
+	// Email matches users whose email contains the value, ignoring case.
+	Email string
+	// Name matches users whose first or last name contains the value, ignoring case.
+	Name string
+	// IsAdmin, when set, only matches users with that is_admin value.
+	IsAdmin *int
+	// Sort is one of UserSortFields, optionally prefixed with "-" for
+	// descending order.
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// Validate reports whether the sort field and paging values are acceptable.
+func (f UserFilter) Validate() error {
+	if f.Sort != "" && !contains(UserSortFields, strings.TrimPrefix(f.Sort, "-")) {
+		return &ValidationError{Field: "sort", Message: "must be one of " + strings.Join(UserSortFields, ", ")}
+	}
+	if f.Page < 0 {
+		return &ValidationError{Field: "page", Message: "must be a positive number"}
+	}
+	if f.PerPage < 0 || f.PerPage > MaxPerPage {
+		return &ValidationError{Field: "per_page", Message: fmt.Sprintf("must be between 1 and %d", MaxPerPage)}
+	}
+	return nil
+}
+
+// SortColumn returns the field to sort by, defaulting to last_name.
+func (f UserFilter) SortColumn() string {
+	if f.Sort == "" {
+		return "last_name"
+	}
+	return strings.TrimPrefix(f.Sort, "-")
+}
+
+// SortDescending reports whether the listing is sorted in descending order.
+func (f UserFilter) SortDescending() bool {
+	return strings.HasPrefix(f.Sort, "-")
+}
+
+// Limit returns the page size, falling back to DefaultPerPage.
+func (f UserFilter) Limit() int {
+	if f.PerPage <= 0 {
+		return DefaultPerPage
+	}
+	return f.PerPage
+}
+
+// Offset returns the number of rows to skip for the requested page.
+func (f UserFilter) Offset() int {
+	if f.Page <= 1 {
+		return 0
+	}
+	return (f.Page - 1) * f.Limit()
+}
+
+// Matches reports whether a single user passes the filter. Stores that can't
+// push filtering down to a query language use this to stay consistent with
+// the Postgres implementation.
+func (f UserFilter) Matches(u *data.User) bool {
+	if f.Email != "" && !strings.Contains(strings.ToLower(u.Email), strings.ToLower(f.Email)) {
+		return false
+	}
+	if f.Name != "" {
+		name := strings.ToLower(f.Name)
+		if !strings.Contains(strings.ToLower(u.FirstName), name) && !strings.Contains(strings.ToLower(u.LastName), name) {
+			return false
+		}
+	}
+	if f.IsAdmin != nil && u.IsAdmin != *f.IsAdmin {
+		return false
+	}
+	return true
+}
+
+// Apply filters, sorts and paginates users in memory. It returns the requested
+// page along with the number of users matching the filter.
+func (f UserFilter) Apply(users []*data.User) ([]*data.User, int) {
+	var matched []*data.User
+	for _, u := range users {
+		if f.Matches(u) {
+			matched = append(matched, u)
+		}
+	}
+
+	column, desc := f.SortColumn(), f.SortDescending()
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		var less, equal bool
+		switch column {
+		case "id":
+			less, equal = a.ID < b.ID, a.ID == b.ID
+		case "email":
+			less, equal = a.Email < b.Email, a.Email == b.Email
+		case "first_name":
+			less, equal = a.FirstName < b.FirstName, a.FirstName == b.FirstName
+		case "is_admin":
+			less, equal = a.IsAdmin < b.IsAdmin, a.IsAdmin == b.IsAdmin
+		case "created_at":
+			less, equal = a.CreatedAt.Before(b.CreatedAt), a.CreatedAt.Equal(b.CreatedAt)
+		default:
+			less, equal = a.LastName < b.LastName, a.LastName == b.LastName
+		}
+		if equal {
+			// keep the order stable across pages
+			return a.ID < b.ID
+		}
+		if desc {
+			return !less
+		}
+		return less
+	})
+
+	total := len(matched)
+	start := f.Offset()
+	if start > total {
+		start = total
+	}
+	end := start + f.Limit()
+	if end > total {
+		end = total
+	}
+
+	return matched[start:end], total
+}
+
+// PageURL returns u, relative to the host, with the page query parameter set
+// to page, for linking to the other pages of a listing.
+func PageURL(u *url.URL, page int) string {
+	qs := u.Query()
+	qs.Set("page", strconv.Itoa(page))
+	return u.Path + "?" + qs.Encode()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"errors"
+	"net/url"
+	"simple-web-app/pkg/data"
+	"testing"
+	"time"
+)
+
+func TestUserFilter_Apply(t *testing.T) {
+	now := time.Now()
+	users := []*data.User{
+		{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com", IsAdmin: 1, CreatedAt: now},
+		{ID: 2, FirstName: "Jack", LastName: "Smith", Email: "jack@example.com", CreatedAt: now.Add(time.Hour)},
+		{ID: 3, FirstName: "Jane", LastName: "Smith", Email: "jane@example.org", CreatedAt: now.Add(2 * time.Hour)},
+	}
+
+	admin, notAdmin := 1, 0
+
+	var tests = []struct {
+		name          string
+		filter        UserFilter
+		expectedIDs   []int
+		expectedTotal int
+	}{
+		{"zero value", UserFilter{}, []int{2, 3, 1}, 3},
+		{"email", UserFilter{Email: "EXAMPLE.ORG"}, []int{3}, 1},
+		{"name matches last name", UserFilter{Name: "smi"}, []int{2, 3}, 2},
+		{"name matches first name", UserFilter{Name: "jane"}, []int{3}, 1},
+		{"admins", UserFilter{IsAdmin: &admin}, []int{1}, 1},
+		{"not admins", UserFilter{IsAdmin: &notAdmin}, []int{2, 3}, 2},
+		{"sort by id descending", UserFilter{Sort: "-id"}, []int{3, 2, 1}, 3},
+		{"sort by created_at", UserFilter{Sort: "created_at"}, []int{1, 2, 3}, 3},
+		{"first page", UserFilter{Sort: "id", PerPage: 2}, []int{1, 2}, 3},
+		{"second page", UserFilter{Sort: "id", PerPage: 2, Page: 2}, []int{3}, 3},
+		{"page out of range", UserFilter{PerPage: 2, Page: 3}, []int{}, 3},
+	}
+
+	for _, test := range tests {
+		page, total := test.filter.Apply(users)
+
+		if total != test.expectedTotal {
+			t.Errorf("%s: expected total %d, got %d", test.name, test.expectedTotal, total)
+		}
+
+		if len(page) != len(test.expectedIDs) {
+			t.Errorf("%s: expected %d users, got %d", test.name, len(test.expectedIDs), len(page))
+			continue
+		}
+
+		for i, u := range page {
+			if u.ID != test.expectedIDs[i] {
+				t.Errorf("%s: expected user %d at position %d, got %d", test.name, test.expectedIDs[i], i, u.ID)
+			}
+		}
+	}
+}
+
+func TestUserFilter_Validate(t *testing.T) {
+	var tests = []struct {
+		name        string
+		filter      UserFilter
+		expectError bool
+	}{
+		{"zero value", UserFilter{}, false},
+		{"valid sort", UserFilter{Sort: "-created_at"}, false},
+		{"invalid sort", UserFilter{Sort: "password"}, true},
+		{"negative page", UserFilter{Page: -1}, true},
+		{"page size too large", UserFilter{PerPage: MaxPerPage + 1}, true},
+	}
+
+	for _, test := range tests {
+		err := test.filter.Validate()
+		if test.expectError && err == nil {
+			t.Errorf("%s: expected an error, but did not get one", test.name)
+		}
+		if !test.expectError && err != nil {
+			t.Errorf("%s: did not expect an error, but got %s", test.name, err)
+		}
+		if err != nil && !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: expected a validation error, but got %s", test.name, err)
+		}
+	}
+}
+
+func TestPageURL(t *testing.T) {
+	var tests = []struct {
+		name     string
+		url      string
+		page     int
+		expected string
+	}{
+		{"no query", "http://example.com/users", 2, "/users?page=2"},
+		{"replaces page", "/users?page=1&per_page=5", 2, "/users?page=2&per_page=5"},
+		{"keeps filters", "/users?name=jack%20smith", 3, "/users?name=jack+smith&page=3"},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.url)
+		if got := PageURL(u, test.page); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
@@ -1,18 +1,35 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"simple-web-app/pkg/data"
 )
 
+// DatabaseRepo is the interface every data store has to satisfy. Every method
+// takes a context as its first argument, so that a cancelled request or an
+// expired deadline stops the work in flight.
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	InsertUser(user data.User) (int, error)
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
+	AllUsers(ctx context.Context) ([]*data.User, error)
+	ListUsers(ctx context.Context, filter UserFilter) ([]*data.User, int, error)
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	UpdateUser(ctx context.Context, u data.User) error
+	DeleteUser(ctx context.Context, id int) error
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	ListUserImages(ctx context.Context, userID int) ([]data.UserImage, error)
+	ActivateUserImage(ctx context.Context, userID, imageID int) error
+	DeleteUserImage(ctx context.Context, userID, imageID int) (*data.UserImage, error)
+	InsertRefreshToken(ctx context.Context, t data.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*data.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id, replacedBy string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+	MarkEmailVerified(ctx context.Context, id int) error
+	InsertToken(ctx context.Context, t data.Token) error
+	ConsumeToken(ctx context.Context, scope string, hash []byte) (int, error)
+	DeleteTokensForUser(ctx context.Context, scope string, userID int) error
 }
@@ -0,0 +1,683 @@
+// Package repotest holds a conformance suite for implementations of
+// repository.DatabaseRepo, so that every store behaves the same way as far as
+// the handlers can tell.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"simple-web-app/pkg/data"
+	"simple-web-app/pkg/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty repository. It is called once per sub test, and
+// may use t.Cleanup to tear the repository down again.
+type Factory func(t *testing.T) repository.DatabaseRepo
+
+// Run runs the conformance suite against the repositories made by newRepo.
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo repository.DatabaseRepo)
+	}{
+		{"CRUD", testCRUD},
+		{"ListUsers", testListUsers},
+		{"NotFound", testNotFound},
+		{"Validation", testValidation},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"ResetPassword", testResetPassword},
+		{"ImageReplacement", testImageReplacement},
+		{"ImageHistory", testImageHistory},
+		{"RefreshTokens", testRefreshTokens},
+		{"EmailVerification", testEmailVerification},
+		{"Tokens", testTokens},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newRepo(t))
+		})
+	}
+}
+
+// insertUser inserts a user with the password "secret", failing the test on error.
+func insertUser(t *testing.T, repo repository.DatabaseRepo, first, last, email string, isAdmin int) int {
+	t.Helper()
+
+	id, err := repo.InsertUser(context.Background(), data.User{
+		FirstName: first,
+		LastName:  last,
+		Email:     email,
+		Password:  "secret",
+		IsAdmin:   isAdmin,
+	})
+	if err != nil {
+		t.Fatalf("inserting %s failed: %s", email, err)
+	}
+
+	return id
+}
+
+func testCRUD(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 1)
+	if id <= 0 {
+		t.Fatalf("insert returned invalid id %d", id)
+	}
+
+	otherID := insertUser(t, repo, "jane", "doe", "jane@example.com", 0)
+	if otherID == id {
+		t.Fatal("insert handed out the same id twice")
+	}
+
+	user, err := repo.GetUser(ctx, id)
+	if err != nil {
+		t.Fatal("get user failed:", err)
+	}
+
+	if user.ID != id || user.FirstName != "jack" || user.LastName != "smith" || user.Email != "jack@example.com" || user.IsAdmin != 1 {
+		t.Errorf("get user returned wrong user: %+v", user)
+	}
+
+	if user.Password == "secret" {
+		t.Error("password stored in plain text")
+	}
+
+	if matches, _ := user.PasswordMatches("secret"); !matches {
+		t.Error("stored password hash does not match")
+	}
+
+	user, err = repo.GetUserByEmail(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatal("get user by email failed:", err)
+	}
+
+	if user.ID != otherID {
+		t.Errorf("get user by email returned user %d, expected %d", user.ID, otherID)
+	}
+
+	// addresses are unique regardless of case, so they are found that way too
+	if found, err := repo.GetUserByEmail(ctx, "Jane@Example.COM"); err != nil || found.ID != otherID {
+		t.Errorf("get user by email in another case: expected user %d, got %+v, %v", otherID, found, err)
+	}
+
+	user.FirstName = "janet"
+	user.Email = "janet@example.com"
+	user.IsAdmin = 1
+	if err := repo.UpdateUser(ctx, *user); err != nil {
+		t.Fatal("update user failed:", err)
+	}
+
+	user, _ = repo.GetUser(ctx, otherID)
+	if user.FirstName != "janet" || user.Email != "janet@example.com" || user.IsAdmin != 1 {
+		t.Errorf("update user did not persist: %+v", user)
+	}
+
+	users, err := repo.AllUsers(ctx)
+	if err != nil {
+		t.Fatal("all users failed:", err)
+	}
+
+	// ordered by last name
+	if len(users) != 2 || users[0].LastName != "doe" || users[1].LastName != "smith" {
+		t.Errorf("all users returned the wrong users or order: %v", users)
+	}
+
+	if err := repo.DeleteUser(ctx, otherID); err != nil {
+		t.Fatal("delete user failed:", err)
+	}
+
+	if _, err := repo.GetUser(ctx, otherID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleted user can still be retrieved: %v", err)
+	}
+
+	users, _ = repo.AllUsers(ctx)
+	if len(users) != 1 {
+		t.Errorf("expected 1 user after delete, got %d", len(users))
+	}
+}
+
+func testListUsers(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	insertUser(t, repo, "ann", "adams", "ann@example.com", 1)
+	insertUser(t, repo, "bob", "baker", "bob@example.org", 0)
+	insertUser(t, repo, "cat", "baker", "cat@example.com", 0)
+
+	notAdmin := 0
+
+	var tests = []struct {
+		name           string
+		filter         repository.UserFilter
+		expectedEmails []string
+		expectedTotal  int
+	}{
+		{"everyone", repository.UserFilter{}, []string{"ann@example.com", "bob@example.org", "cat@example.com"}, 3},
+		{"by email", repository.UserFilter{Email: "EXAMPLE.ORG"}, []string{"bob@example.org"}, 1},
+		{"by name", repository.UserFilter{Name: "BAK"}, []string{"bob@example.org", "cat@example.com"}, 2},
+		{"by is_admin", repository.UserFilter{IsAdmin: &notAdmin}, []string{"bob@example.org", "cat@example.com"}, 2},
+		{"sorted descending", repository.UserFilter{Sort: "-email"}, []string{"cat@example.com", "bob@example.org", "ann@example.com"}, 3},
+		{"second page", repository.UserFilter{Sort: "email", PerPage: 2, Page: 2}, []string{"cat@example.com"}, 3},
+	}
+
+	for _, test := range tests {
+		users, total, err := repo.ListUsers(ctx, test.filter)
+		if err != nil {
+			t.Errorf("%s: list users failed: %s", test.name, err)
+			continue
+		}
+
+		if total != test.expectedTotal {
+			t.Errorf("%s: expected total %d, got %d", test.name, test.expectedTotal, total)
+		}
+
+		if len(users) != len(test.expectedEmails) {
+			t.Errorf("%s: expected %d users, got %d", test.name, len(test.expectedEmails), len(users))
+			continue
+		}
+
+		for i, u := range users {
+			if u.Email != test.expectedEmails[i] {
+				t.Errorf("%s: expected %s at position %d, got %s", test.name, test.expectedEmails[i], i, u.Email)
+			}
+		}
+	}
+
+	if _, _, err := repo.ListUsers(ctx, repository.UserFilter{Sort: "password"}); err == nil {
+		t.Error("list users accepted an invalid sort field")
+	}
+}
+
+func testNotFound(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	missing := 1000
+
+	var tests = []struct {
+		name string
+		fn   func() error
+	}{
+		{"get user", func() error {
+			_, err := repo.GetUser(ctx, missing)
+			return err
+		}},
+		{"get user by email", func() error {
+			_, err := repo.GetUserByEmail(ctx, "nobody@example.com")
+			return err
+		}},
+		{"update user", func() error {
+			return repo.UpdateUser(ctx, data.User{ID: missing, Email: "nobody@example.com"})
+		}},
+		{"delete user", func() error {
+			return repo.DeleteUser(ctx, missing)
+		}},
+		{"reset password", func() error {
+			return repo.ResetPassword(ctx, missing, "password")
+		}},
+		{"insert user image", func() error {
+			_, err := repo.InsertUserImage(ctx, data.UserImage{UserID: missing, FileName: "x.png"})
+			return err
+		}},
+		{"activate user image", func() error {
+			return repo.ActivateUserImage(ctx, missing, missing)
+		}},
+		{"delete user image", func() error {
+			_, err := repo.DeleteUserImage(ctx, missing, missing)
+			return err
+		}},
+		{"mark email verified", func() error {
+			return repo.MarkEmailVerified(ctx, missing)
+		}},
+		{"insert token", func() error {
+			return repo.InsertToken(ctx, data.Token{Hash: data.HashToken("orphan"), UserID: missing, Scope: data.ScopeVerifyEmail, ExpiresAt: time.Now().Add(time.Hour)})
+		}},
+		{"consume token", func() error {
+			_, err := repo.ConsumeToken(ctx, data.ScopeVerifyEmail, data.HashToken("missing"))
+			return err
+		}},
+		{"insert refresh token", func() error {
+			return repo.InsertRefreshToken(ctx, data.RefreshToken{ID: "orphan", UserID: missing, FamilyID: "orphan", ExpiresAt: time.Now()})
+		}},
+		{"get refresh token", func() error {
+			_, err := repo.GetRefreshToken(ctx, "missing")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		if err := test.fn(); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", test.name, err)
+		}
+	}
+}
+
+func testValidation(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	_, err := repo.InsertUser(ctx, data.User{FirstName: "jack", Password: "secret"})
+	var validationErr *repository.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "email" {
+		t.Errorf("insert user without email: expected a validation error for email, got %v", err)
+	}
+
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+	err = repo.UpdateUser(ctx, data.User{ID: id, FirstName: "jack", Email: " "})
+	if !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("update user without email: expected ErrValidation, got %v", err)
+	}
+
+	_, _, err = repo.ListUsers(ctx, repository.UserFilter{Sort: "password"})
+	if !errors.Is(err, repository.ErrValidation) {
+		t.Errorf("list users with an invalid sort field: expected ErrValidation, got %v", err)
+	}
+}
+
+func testDuplicateEmail(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+	otherID := insertUser(t, repo, "jane", "smith", "jane@example.com", 0)
+
+	if _, err := repo.InsertUser(ctx, data.User{Email: "jack@example.com", Password: "secret"}); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("insert duplicate email: expected ErrDuplicateEmail, got %v", err)
+	}
+
+	if _, err := repo.InsertUser(ctx, data.User{Email: "JACK@example.com", Password: "secret"}); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("insert duplicate email in a different case: expected ErrDuplicateEmail, got %v", err)
+	}
+
+	other, _ := repo.GetUser(ctx, otherID)
+	other.Email = "jack@example.com"
+	if err := repo.UpdateUser(ctx, *other); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("update to a duplicate email: expected ErrDuplicateEmail, got %v", err)
+	}
+
+	// keeping your own email is fine
+	other.Email = "jane@example.com"
+	other.FirstName = "janet"
+	if err := repo.UpdateUser(ctx, *other); err != nil {
+		t.Error("updating a user without changing the email failed:", err)
+	}
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 2 {
+		t.Errorf("expected 2 users, got %d", len(users))
+	}
+}
+
+func testResetPassword(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+
+	if err := repo.ResetPassword(ctx, id, "new password"); err != nil {
+		t.Fatal("reset password failed:", err)
+	}
+
+	user, _ := repo.GetUser(ctx, id)
+
+	if matches, _ := user.PasswordMatches("new password"); !matches {
+		t.Error("new password does not match")
+	}
+
+	if matches, _ := user.PasswordMatches("secret"); matches {
+		t.Error("old password still matches")
+	}
+}
+
+func testImageReplacement(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+
+	user, _ := repo.GetUser(ctx, id)
+	if user.ProfilePic.FileName != "" {
+		t.Errorf("new user already has a profile picture: %q", user.ProfilePic.FileName)
+	}
+
+	firstID, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "first.png", StorageKey: "avatars/1.png"})
+	if err != nil {
+		t.Fatal("insert user image failed:", err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if user.ProfilePic.FileName != "first.png" || user.ProfilePic.StorageKey != "avatars/1.png" {
+		t.Errorf("expected profile picture first.png at avatars/1.png, got %q at %q", user.ProfilePic.FileName, user.ProfilePic.StorageKey)
+	}
+
+	secondID, err := repo.InsertUserImage(ctx, data.UserImage{
+		UserID:     id,
+		FileName:   "second.png",
+		StorageKey: "avatars/2.png",
+		Variants:   []data.ImageVariant{{Name: "thumbnail", StorageKey: "avatars/2-thumbnail.png", Width: 64, Height: 48}},
+	})
+	if err != nil {
+		t.Fatal("insert user image failed:", err)
+	}
+
+	if secondID == firstID {
+		t.Error("insert user image handed out the same id twice")
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if user.ProfilePic.FileName != "second.png" {
+		t.Errorf("expected profile picture to be replaced by second.png, got %q", user.ProfilePic.FileName)
+	}
+
+	user, _ = repo.GetUserByEmail(ctx, "jack@example.com")
+	if user.ProfilePic.StorageKey != "avatars/2.png" {
+		t.Errorf("get user by email: expected profile picture at avatars/2.png, got %q", user.ProfilePic.StorageKey)
+	}
+
+	expected := data.ImageVariant{Name: "thumbnail", StorageKey: "avatars/2-thumbnail.png", Width: 64, Height: 48}
+	if len(user.ProfilePic.Variants) != 1 || user.ProfilePic.Variants[0] != expected {
+		t.Errorf("expected variants %v, got %v", []data.ImageVariant{expected}, user.ProfilePic.Variants)
+	}
+}
+
+func testImageHistory(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+	otherID := insertUser(t, repo, "jill", "smith", "jill@example.com", 0)
+
+	var imageIDs []int
+	for _, key := range []string{"avatars/1.png", "avatars/2.png", "avatars/3.png"} {
+		imageID, err := repo.InsertUserImage(ctx, data.UserImage{UserID: id, FileName: "me.png", StorageKey: key})
+		if err != nil {
+			t.Fatal("insert user image failed:", err)
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+	otherImageID, _ := repo.InsertUserImage(ctx, data.UserImage{UserID: otherID, FileName: "jill.png", StorageKey: "avatars/jill.png"})
+
+	// activeKey returns the key of the profile picture of the user, checking
+	// that exactly that image is listed as active
+	activeKey := func() string {
+		t.Helper()
+
+		images, err := repo.ListUserImages(ctx, id)
+		if err != nil {
+			t.Fatal("list user images failed:", err)
+		}
+
+		var active []string
+		for _, i := range images {
+			if i.Active {
+				active = append(active, i.StorageKey)
+			}
+		}
+
+		user, _ := repo.GetUser(ctx, id)
+		if len(active) > 1 || (len(active) == 1) != (user.ProfilePic.StorageKey != "") || (len(active) == 1 && active[0] != user.ProfilePic.StorageKey) {
+			t.Errorf("listed %v as active, but the profile picture is %q", active, user.ProfilePic.StorageKey)
+		}
+		return user.ProfilePic.StorageKey
+	}
+
+	images, err := repo.ListUserImages(ctx, id)
+	if err != nil {
+		t.Fatal("list user images failed:", err)
+	}
+
+	var keys []string
+	for _, i := range images {
+		keys = append(keys, i.StorageKey)
+		if i.UserID != id || i.FileName != "me.png" || i.CreatedAt.IsZero() {
+			t.Errorf("unexpected image %+v", i)
+		}
+	}
+	if strings.Join(keys, ",") != "avatars/3.png,avatars/2.png,avatars/1.png" {
+		t.Errorf("expected every image, newest first, got %v", keys)
+	}
+
+	if key := activeKey(); key != "avatars/3.png" {
+		t.Errorf("expected the latest image to be active, got %q", key)
+	}
+
+	if err := repo.ActivateUserImage(ctx, id, imageIDs[0]); err != nil {
+		t.Fatal("activate user image failed:", err)
+	}
+	if key := activeKey(); key != "avatars/1.png" {
+		t.Errorf("expected the activated image to be the profile picture, got %q", key)
+	}
+
+	if err := repo.ActivateUserImage(ctx, id, imageIDs[0]); err != nil {
+		t.Error("activating the active image again failed:", err)
+	}
+
+	if err := repo.ActivateUserImage(ctx, id, otherImageID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("activating the image of another user: expected ErrNotFound, got %v", err)
+	}
+	if _, err := repo.DeleteUserImage(ctx, id, otherImageID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("deleting the image of another user: expected ErrNotFound, got %v", err)
+	}
+
+	deleted, err := repo.DeleteUserImage(ctx, id, imageIDs[1])
+	if err != nil {
+		t.Fatal("delete user image failed:", err)
+	}
+	if deleted.StorageKey != "avatars/2.png" {
+		t.Errorf("expected the deleted image to be returned, got %+v", deleted)
+	}
+	if key := activeKey(); key != "avatars/1.png" {
+		t.Errorf("deleting another image changed the profile picture to %q", key)
+	}
+
+	if _, err := repo.DeleteUserImage(ctx, id, imageIDs[0]); err != nil {
+		t.Fatal("delete user image failed:", err)
+	}
+	if key := activeKey(); key != "" {
+		t.Errorf("expected no profile picture after deleting it, got %q", key)
+	}
+
+	images, _ = repo.ListUserImages(ctx, id)
+	if len(images) != 1 || images[0].ID != imageIDs[2] {
+		t.Errorf("expected only the third image to be left, got %+v", images)
+	}
+
+	if other, _ := repo.GetUser(ctx, otherID); other.ProfilePic.StorageKey != "avatars/jill.png" {
+		t.Errorf("the profile picture of another user changed to %q", other.ProfilePic.StorageKey)
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+
+	for _, tokenID := range []string{"first", "second"} {
+		err := repo.InsertRefreshToken(ctx, data.RefreshToken{
+			ID:        tokenID,
+			UserID:    id,
+			FamilyID:  "first",
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal("insert refresh token failed:", err)
+		}
+	}
+
+	stored, err := repo.GetRefreshToken(ctx, "first")
+	if err != nil {
+		t.Fatal("get refresh token failed:", err)
+	}
+
+	if stored.UserID != id || stored.FamilyID != "first" || stored.Revoked {
+		t.Errorf("get refresh token returned the wrong token: %+v", stored)
+	}
+
+	err = repo.InsertRefreshToken(ctx, data.RefreshToken{ID: "first", UserID: id, FamilyID: "first", ExpiresAt: time.Now()})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("insert duplicate refresh token: expected ErrConflict, got %v", err)
+	}
+
+	ok, err := repo.RevokeRefreshToken(ctx, "first", "second")
+	if err != nil || !ok {
+		t.Errorf("revoke refresh token failed: %t %v", ok, err)
+	}
+
+	ok, _ = repo.RevokeRefreshToken(ctx, "first", "second")
+	if ok {
+		t.Error("revoking a revoked refresh token reported success")
+	}
+
+	stored, _ = repo.GetRefreshToken(ctx, "first")
+	if !stored.Revoked || stored.ReplacedBy != "second" {
+		t.Errorf("expected revoked token replaced by second, got %+v", stored)
+	}
+
+	if err := repo.RevokeRefreshTokenFamily(ctx, "first"); err != nil {
+		t.Fatal("revoke refresh token family failed:", err)
+	}
+
+	stored, _ = repo.GetRefreshToken(ctx, "second")
+	if !stored.Revoked {
+		t.Error("refresh token family was not revoked")
+	}
+
+	// revoking the tokens of a user leaves those of others alone
+	other := insertUser(t, repo, "jill", "smith", "jill@example.com", 0)
+	for _, tok := range []data.RefreshToken{
+		{ID: "third", UserID: id, FamilyID: "third", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "others", UserID: other, FamilyID: "others", ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := repo.InsertRefreshToken(ctx, tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := repo.RevokeUserRefreshTokens(ctx, id); err != nil {
+		t.Fatal("revoke user refresh tokens failed:", err)
+	}
+
+	stored, _ = repo.GetRefreshToken(ctx, "third")
+	if !stored.Revoked {
+		t.Error("refresh token of the user was not revoked")
+	}
+	stored, _ = repo.GetRefreshToken(ctx, "others")
+	if stored.Revoked {
+		t.Error("refresh token of another user was revoked")
+	}
+
+	// deleting the user takes their tokens along
+	if err := repo.DeleteUser(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetRefreshToken(ctx, "first"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("refresh token survived deleting its user: %v", err)
+	}
+}
+
+func testEmailVerification(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	// new users are never verified, whatever the caller says
+	id, err := repo.InsertUser(ctx, data.User{FirstName: "jack", LastName: "smith", Email: "jack@example.com", Password: "secret", Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := repo.GetUser(ctx, id)
+	if user.Verified {
+		t.Error("new user is verified")
+	}
+
+	if err := repo.MarkEmailVerified(ctx, id); err != nil {
+		t.Fatal("mark email verified failed:", err)
+	}
+
+	user, _ = repo.GetUserByEmail(ctx, "jack@example.com")
+	if !user.Verified {
+		t.Error("user is not verified after marking the email verified")
+	}
+
+	users, _ := repo.AllUsers(ctx)
+	if len(users) != 1 || !users[0].Verified {
+		t.Error("all users does not report the user as verified")
+	}
+
+	// the address stays verified when other details, or only its case, change
+	user.FirstName = "jacky"
+	user.Email = "Jack@example.com"
+	if err := repo.UpdateUser(ctx, *user); err != nil {
+		t.Fatal("update user failed:", err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if !user.Verified {
+		t.Error("user is not verified after updating their name")
+	}
+
+	// but a new address has to be confirmed again
+	user.Email = "jacky@example.com"
+	if err := repo.UpdateUser(ctx, *user); err != nil {
+		t.Fatal("update user failed:", err)
+	}
+
+	user, _ = repo.GetUser(ctx, id)
+	if user.Verified {
+		t.Error("user is still verified after changing their email address")
+	}
+}
+
+func testTokens(t *testing.T, repo repository.DatabaseRepo) {
+	ctx := context.Background()
+	id := insertUser(t, repo, "jack", "smith", "jack@example.com", 0)
+
+	token, err := data.GenerateToken(id, time.Hour, data.ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.InsertToken(ctx, *token); err != nil {
+		t.Fatal("insert token failed:", err)
+	}
+
+	if err := repo.InsertToken(ctx, *token); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("insert duplicate token: expected ErrConflict, got %v", err)
+	}
+
+	// a token is only good for its own scope
+	if _, err := repo.ConsumeToken(ctx, data.ScopeVerifyEmail, token.Hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("consume token with the wrong scope: expected ErrNotFound, got %v", err)
+	}
+
+	userID, err := repo.ConsumeToken(ctx, data.ScopePasswordReset, data.HashToken(token.Plaintext))
+	if err != nil {
+		t.Fatal("consume token failed:", err)
+	}
+
+	if userID != id {
+		t.Errorf("consume token returned user %d, expected %d", userID, id)
+	}
+
+	// tokens can only be used once
+	if _, err := repo.ConsumeToken(ctx, data.ScopePasswordReset, token.Hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("consume used token: expected ErrNotFound, got %v", err)
+	}
+
+	expired, _ := data.GenerateToken(id, -time.Minute, data.ScopePasswordReset)
+	_ = repo.InsertToken(ctx, *expired)
+	if _, err := repo.ConsumeToken(ctx, data.ScopePasswordReset, expired.Hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("consume expired token: expected ErrNotFound, got %v", err)
+	}
+
+	reset, _ := data.GenerateToken(id, time.Hour, data.ScopePasswordReset)
+	verify, _ := data.GenerateToken(id, time.Hour, data.ScopeVerifyEmail)
+	_ = repo.InsertToken(ctx, *reset)
+	_ = repo.InsertToken(ctx, *verify)
+
+	if err := repo.DeleteTokensForUser(ctx, data.ScopePasswordReset, id); err != nil {
+		t.Fatal("delete tokens for user failed:", err)
+	}
+
+	if _, err := repo.ConsumeToken(ctx, data.ScopePasswordReset, reset.Hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("consume deleted token: expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := repo.ConsumeToken(ctx, data.ScopeVerifyEmail, verify.Hash); err != nil {
+		t.Errorf("deleting tokens of one scope removed a token of another: %v", err)
+	}
+}
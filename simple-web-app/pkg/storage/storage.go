@@ -0,0 +1,80 @@
+// Package storage keeps files, such as the images users upload, by key. The
+// filesystem store keeps them in a local directory, which suits development
+// and a single instance; the S3 store keeps them in a bucket of any S3
+// compatible service, so that several instances of the app see the same files.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no object is stored under a key.
+	ErrNotFound = errors.New("storage: object not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute or step
+	// out of the store with "..".
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// Store keeps objects by key. Keys are slash separated paths, e.g.
+// "avatars/4f1c.png".
+type Store interface {
+	// Put stores the content of r under key, replacing any object stored
+	// there before.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Open returns the content of the object stored under key, or
+	// ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting an object that
+	// does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns where browsers can load the object stored under key from.
+	// The URL may expire, so it is resolved when it is needed rather than
+	// stored.
+	URL(ctx context.Context, key string) (string, error)
+}
+
+// checkKey returns ErrInvalidKey unless key is a clean, relative path.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) || path.Clean(key) != key {
+		return ErrInvalidKey
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// escapePath percent-encodes every byte of p except the unreserved
+// characters of RFC 3986 and slashes, the way S3 expects in signed paths.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// escapeQuery is escapePath for query names and values, where slashes are
+// encoded too.
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+func isUnreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_checkKey(t *testing.T) {
+	var tests = []struct {
+		key   string
+		valid bool
+	}{
+		{"img.png", true},
+		{"avatars/2/img.png", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"../img.png", false},
+		{"avatars/../../img.png", false},
+		{"avatars//img.png", false},
+		{`avatars\img.png`, false},
+	}
+
+	for _, test := range tests {
+		if err := checkKey(test.key); (err == nil) != test.valid {
+			t.Errorf("%q: expected valid to be %t, but got %v", test.key, test.valid, err)
+		}
+	}
+}
+
+// testStore runs through the life of an object in store.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "avatars/img.png", strings.NewReader("first"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(ctx, "avatars/img.png", strings.NewReader("second"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Open(ctx, "avatars/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "second" {
+		t.Errorf("expected the replaced content, but got %q", content)
+	}
+
+	if err := store.Delete(ctx, "avatars/img.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, "avatars/img.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deleting, but got %v", err)
+	}
+	if err := store.Delete(ctx, "avatars/img.png"); err != nil {
+		t.Errorf("deleting twice failed: %s", err)
+	}
+
+	if err := store.Put(ctx, "../img.png", strings.NewReader("x"), "image/png"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for a key out of the store, but got %v", err)
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	store := &Filesystem{Dir: filepath.Join(dir, "img"), BaseURL: "/static/img/"}
+
+	testStore(t, store)
+
+	if _, err := os.Stat(filepath.Join(dir, "img.png")); err == nil {
+		t.Error("an invalid key was written outside of the directory")
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "img", "avatars"))
+	if len(entries) != 0 {
+		t.Errorf("files were left behind: %v", entries)
+	}
+
+	u, err := store.URL(context.Background(), "avatars/my img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "/static/img/avatars/my%20img.png" {
+		t.Errorf("unexpected URL %s", u)
+	}
+}
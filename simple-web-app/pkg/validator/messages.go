@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Messages maps the keys of failures to message templates, in which
+// placeholders such as {min} are replaced by the parameters of the failure.
+// Translations are catalogs with the same keys.
+type Messages map[string]string
+
+// DefaultMessages is the English catalog.
+var DefaultMessages = Messages{
+	"required":   "This field cannot be blank",
+	"email":      "Invalid email address",
+	"min_length": "This field must be at least {min} characters long",
+	"max_length": "This field must be at most {max} characters long",
+	"equals":     "This field must match {other}",
+	"number":     "This field must be a whole number",
+	"between":    "This field must be between {min} and {max}",
+	"one_of":     "This field must be one of {values}",
+	"pattern":    "This field has an invalid format",
+}
+
+// Render returns the message for f. Keys missing from m fall back to
+// DefaultMessages, and failing that to the key itself.
+func (m Messages) Render(f *Failure) string {
+	template, ok := m[f.Key]
+	if !ok {
+		template, ok = DefaultMessages[f.Key]
+	}
+	if !ok {
+		template = f.Key
+	}
+
+	if len(f.Params) == 0 {
+		return template
+	}
+
+	replacements := make([]string, 0, 2*len(f.Params))
+	for name, value := range f.Params {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
@@ -0,0 +1,123 @@
+package validator
+
+import (
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Failure describes why a rule rejected a value. Key names the message in a
+// Messages catalog, and Params fill in its placeholders.
+type Failure struct {
+	Key    string
+	Params map[string]any
+}
+
+// Rule checks a single value, returning nil if it accepts it. Apart from
+// Required, rules accept an empty value, so that optional fields can have
+// rules too.
+type Rule func(value string) *Failure
+
+// Required rejects values that are empty or only white space.
+func Required() Rule {
+	return func(value string) *Failure {
+		if strings.TrimSpace(value) == "" {
+			return &Failure{Key: "required"}
+		}
+		return nil
+	}
+}
+
+// Email rejects values that are not a bare email address, such as
+// "jack@example.com".
+func Email() Rule {
+	return func(value string) *Failure {
+		if value == "" {
+			return nil
+		}
+
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Address != value {
+			return &Failure{Key: "email"}
+		}
+		return nil
+	}
+}
+
+// MinLength rejects values shorter than n characters.
+func MinLength(n int) Rule {
+	return func(value string) *Failure {
+		if value != "" && utf8.RuneCountInString(value) < n {
+			return &Failure{Key: "min_length", Params: map[string]any{"min": n}}
+		}
+		return nil
+	}
+}
+
+// MaxLength rejects values longer than n characters.
+func MaxLength(n int) Rule {
+	return func(value string) *Failure {
+		if utf8.RuneCountInString(value) > n {
+			return &Failure{Key: "max_length", Params: map[string]any{"max": n}}
+		}
+		return nil
+	}
+}
+
+// Equals rejects values other than expected, the value of the field named
+// other, e.g. a password confirmation that has to match the password.
+func Equals(other, expected string) Rule {
+	return func(value string) *Failure {
+		if value != expected {
+			return &Failure{Key: "equals", Params: map[string]any{"other": other}}
+		}
+		return nil
+	}
+}
+
+// Between rejects values that are not whole numbers from min to max, inclusive.
+func Between(min, max int) Rule {
+	return func(value string) *Failure {
+		if value == "" {
+			return nil
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return &Failure{Key: "number"}
+		}
+		if n < min || n > max {
+			return &Failure{Key: "between", Params: map[string]any{"min": min, "max": max}}
+		}
+		return nil
+	}
+}
+
+// OneOf rejects values that are not in permitted.
+func OneOf(permitted ...string) Rule {
+	return func(value string) *Failure {
+		if value == "" {
+			return nil
+		}
+
+		for _, p := range permitted {
+			if value == p {
+				return nil
+			}
+		}
+		return &Failure{Key: "one_of", Params: map[string]any{"values": strings.Join(permitted, ", ")}}
+	}
+}
+
+// Pattern rejects values that do not match re. Anchor re with ^ and $ to match
+// the whole value.
+func Pattern(re *regexp.Regexp) Rule {
+	return func(value string) *Failure {
+		if value != "" && !re.MatchString(value) {
+			return &Failure{Key: "pattern"}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,94 @@
+// Package validator checks user input against declarative rules. The api
+// validates its JSON payloads with it, and the web app builds its Form on top
+// of it, so both reject the same values with the same messages.
+package validator
+
+import (
+	"sort"
+	"strings"
+)
+
+// Errors holds the messages of every field that failed validation, keyed by
+// field name. It is an error, so a failed validation can be returned as one.
+type Errors map[string][]string
+
+// Get returns the first message of field, or an empty string.
+func (e Errors) Get(field string) string {
+	errorSlice := e[field]
+
+	if len(errorSlice) == 0 {
+		return ""
+	}
+
+	return errorSlice[0]
+}
+
+// Add records a message for field.
+func (e Errors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+// Fields returns the names of the fields with errors, sorted.
+func (e Errors) Fields() []string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Error lists every message, ordered by field name.
+func (e Errors) Error() string {
+	var messages []string
+	for _, field := range e.Fields() {
+		for _, message := range e[field] {
+			messages = append(messages, field+": "+message)
+		}
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Validator applies rules to fields and collects the failures, rendered with
+// Messages. A nil Messages uses DefaultMessages.
+type Validator struct {
+	Errors   Errors
+	Messages Messages
+}
+
+// New returns a Validator without errors, using DefaultMessages.
+func New() *Validator {
+	return &Validator{Errors: Errors{}, Messages: DefaultMessages}
+}
+
+// Field checks value against rules in order, recording the message of the
+// first rule that rejects it.
+func (v *Validator) Field(field, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if failure := rule(value); failure != nil {
+			v.Errors.Add(field, v.Messages.Render(failure))
+			return
+		}
+	}
+}
+
+// Check records message for field unless ok.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Errors.Add(field, message)
+	}
+}
+
+// Valid reports whether every check passed.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Err returns the collected Errors, or nil if every check passed.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return v.Errors
+}
@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	var tests = []struct {
+		name     string
+		rule     Rule
+		value    string
+		expected bool
+	}{
+		{"required", Required(), "jack", true},
+		{"required empty", Required(), "", false},
+		{"required blank", Required(), "  ", false},
+		{"email", Email(), "jack@example.com", true},
+		{"email empty", Email(), "", true},
+		{"email without domain", Email(), "jack@", false},
+		{"email with name", Email(), "Jack <jack@example.com>", false},
+		{"min length", MinLength(3), "abc", true},
+		{"min length too short", MinLength(3), "ab", false},
+		{"min length counts characters", MinLength(3), "äöü", true},
+		{"max length", MaxLength(3), "abc", true},
+		{"max length too long", MaxLength(3), "abcd", false},
+		{"max length counts characters", MaxLength(3), "äöü", true},
+		{"equals", Equals("password", "secret"), "secret", true},
+		{"equals mismatch", Equals("password", "secret"), "Secret", false},
+		{"between", Between(1, 10), "10", true},
+		{"between too small", Between(1, 10), "0", false},
+		{"between not a number", Between(1, 10), "ten", false},
+		{"one of", OneOf("en", "de"), "de", true},
+		{"one of other", OneOf("en", "de"), "fr", false},
+		{"pattern", Pattern(regexp.MustCompile(`^[a-z]+$`)), "jack", true},
+		{"pattern mismatch", Pattern(regexp.MustCompile(`^[a-z]+$`)), "Jack1", false},
+	}
+
+	for _, test := range tests {
+		failure := test.rule(test.value)
+		if (failure == nil) != test.expected {
+			t.Errorf("%s: expected %t but got %+v", test.name, test.expected, failure)
+		}
+		if failure != nil && failure.Key == "" {
+			t.Errorf("%s: rejected without a message key", test.name)
+		}
+	}
+}
+
+func TestMessages_Render(t *testing.T) {
+	german := Messages{
+		"required":   "Dieses Feld darf nicht leer sein",
+		"min_length": "Mindestens {min} Zeichen",
+	}
+
+	var tests = []struct {
+		name     string
+		messages Messages
+		failure  *Failure
+		expected string
+	}{
+		{"default", DefaultMessages, &Failure{Key: "required"}, "This field cannot be blank"},
+		{"nil catalog", nil, &Failure{Key: "email"}, "Invalid email address"},
+		{"parameters", DefaultMessages, &Failure{Key: "between", Params: map[string]any{"min": 1, "max": 10}}, "This field must be between 1 and 10"},
+		{"translated", german, &Failure{Key: "min_length", Params: map[string]any{"min": 8}}, "Mindestens 8 Zeichen"},
+		{"missing translation", german, &Failure{Key: "email"}, "Invalid email address"},
+		{"unknown key", german, &Failure{Key: "custom"}, "custom"},
+	}
+
+	for _, test := range tests {
+		if message := test.messages.Render(test.failure); message != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.name, test.expected, message)
+		}
+	}
+}
+
+func TestValidator_Field(t *testing.T) {
+	v := New()
+	v.Field("email", "", Required(), Email())
+	v.Field("name", "Jack", Required(), MaxLength(10))
+
+	if v.Valid() {
+		t.Error("validator is valid with a missing email")
+	}
+
+	if len(v.Errors["email"]) != 1 {
+		t.Errorf("expected the first failing rule only, got %v", v.Errors["email"])
+	}
+
+	if v.Errors.Get("name") != "" {
+		t.Errorf("valid field has an error: %s", v.Errors.Get("name"))
+	}
+}
+
+func TestValidator_Err(t *testing.T) {
+	v := New()
+	if v.Err() != nil {
+		t.Error("new validator returned an error")
+	}
+
+	v.Check(false, "id", "must be set")
+	v.Field("email", "jack", Email())
+
+	err := v.Err()
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected Errors, got %T", err)
+	}
+
+	if err.Error() != "email: Invalid email address; id: must be set" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,7 @@
+-- Development data. Apply it after migrating, e.g.
+--   go run ./cmd/migrate up
+--   psql "host=localhost user=postgres password=postgres dbname=users" -f sql/seed.sql
+-- The admin password is "secret".
+
+INSERT INTO public.users (first_name, last_name, email, password, is_admin, verified, created_at, updated_at)
+VALUES ('Admin', 'User', 'admin@example.com', '$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK', 1, true, '2022-08-19 00:00:00', '2022-08-19 00:00:00');
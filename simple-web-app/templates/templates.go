@@ -0,0 +1,9 @@
+// Package templates embeds the HTML templates of the web app in the binary.
+// Pages are the *.page.gohtml files; they fill in the "base" layout from the
+// *.layout.gohtml files and may use the templates defined in partials/.
+package templates
+
+import "embed"
+
+//go:embed *.gohtml partials/*.gohtml
+var FS embed.FS